@@ -18,6 +18,7 @@
 package mclock
 
 import (
+	"sync"
 	"time"
 
 	"github.com/aristanetworks/goarista/monotime"
@@ -28,3 +29,101 @@ type AbsTime time.Duration // absolute monotonic time
 func Now() AbsTime {
 	return AbsTime(monotime.Now())
 }
+
+// Add returns t + d
+func (t AbsTime) Add(d time.Duration) AbsTime {
+	return t + AbsTime(d)
+}
+
+// Time converts t to a time.Time counted from the zero time, used to timestamp
+// events in virtual time
+func (t AbsTime) Time() time.Time {
+	return time.Time{}.Add(time.Duration(t))
+}
+
+// Sub returns the duration t - t2
+func (t AbsTime) Sub(t2 AbsTime) time.Duration {
+	return time.Duration(t - t2)
+}
+
+// Clock interface makes it possible to replace the monotonic system clock with
+// a simulated clock (see Simulated)
+type Clock interface {
+	Now() AbsTime
+	Sleep(time.Duration)
+	After(time.Duration) <-chan time.Time
+}
+
+// System implements Clock using the system clock
+type System struct{}
+
+// Now returns the current monotonic time
+func (System) Now() AbsTime {
+	return Now()
+}
+
+// Sleep blocks for the given duration
+func (System) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// After returns a channel which receives the current time after d has elapsed
+func (System) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Ticker delivers the ticks of a clock at regular intervals, it is the
+// equivalent of time.Ticker for any Clock
+type Ticker struct {
+	C     <-chan time.Time
+	quitc chan struct{}
+	once  sync.Once
+}
+
+// funcClock is implemented by clocks that call functions when they are due
+// (see Simulated.AfterFunc)
+type funcClock interface {
+	AfterFunc(time.Duration, func())
+}
+
+// NewTicker returns a Ticker ticking every d on the clock, ticks are dropped
+// if the receiver is not ready
+// on a Simulated clock ticks are not dropped, Run waits for the previous tick
+// to be received before delivering the next one, so that a run of n*d ticks n
+// times, the receiver has to stop the ticker once it stops receiving
+func NewTicker(clock Clock, d time.Duration) *Ticker {
+	c := make(chan time.Time, 1)
+	self := &Ticker{C: c, quitc: make(chan struct{})}
+	if fc, ok := clock.(funcClock); ok {
+		var tick func()
+		tick = func() {
+			select {
+			case c <- clock.Now().Time():
+			case <-self.quitc:
+				return
+			}
+			fc.AfterFunc(d, tick)
+		}
+		fc.AfterFunc(d, tick)
+		return self
+	}
+	go func() {
+		for {
+			select {
+			case t := <-clock.After(d):
+				select {
+				case c <- t:
+				default:
+				}
+			case <-self.quitc:
+				return
+			}
+		}
+	}()
+	return self
+}
+
+// Stop turns off the ticker, it does not close the channel
+func (self *Ticker) Stop() {
+	self.once.Do(func() { close(self.quitc) })
+}
@@ -0,0 +1,138 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package mclock
+
+import (
+	"sync"
+	"time"
+)
+
+// Simulated implements a virtual Clock for reproducible time-sensitive tests
+// and simulations. Time only advances when Run is called, timers scheduled
+// for the same time fire in the order they were created.
+//
+// The zero value is a valid clock starting at time zero
+type Simulated struct {
+	now       AbsTime
+	scheduled []*simTimer
+	seq       int
+	mu        sync.Mutex
+	cond      *sync.Cond
+}
+
+type simTimer struct {
+	at  AbsTime
+	seq int
+	c   chan time.Time // receives the time, nil for callbacks
+	f   func()
+}
+
+func (self *Simulated) init() {
+	if self.cond == nil {
+		self.cond = sync.NewCond(&self.mu)
+	}
+}
+
+// Run advances the clock by d, firing all timers that are due in order
+// timers are fired one at a time without holding the lock, so that callbacks
+// (see AfterFunc) can schedule timers that are due within the same run
+// Run must not be called concurrently
+func (self *Simulated) Run(d time.Duration) {
+	self.mu.Lock()
+	self.init()
+	end := self.now.Add(d)
+	for len(self.scheduled) > 0 && self.scheduled[0].at <= end {
+		t := self.scheduled[0]
+		self.scheduled = self.scheduled[1:]
+		self.now = t.at
+		self.mu.Unlock()
+		if t.f != nil {
+			t.f()
+		} else {
+			t.c <- t.at.Time()
+		}
+		self.mu.Lock()
+	}
+	self.now = end
+	self.mu.Unlock()
+}
+
+// ActiveTimers returns the number of timers that have not fired yet
+func (self *Simulated) ActiveTimers() int {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return len(self.scheduled)
+}
+
+// WaitForTimers blocks until at least n timers are scheduled, used to make
+// sure that go routines of a simulation are waiting on the clock before
+// advancing it
+func (self *Simulated) WaitForTimers(n int) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.init()
+	for len(self.scheduled) < n {
+		self.cond.Wait()
+	}
+}
+
+// Now returns the current virtual time
+func (self *Simulated) Now() AbsTime {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.now
+}
+
+// Sleep blocks until the clock has advanced by d
+func (self *Simulated) Sleep(d time.Duration) {
+	<-self.After(d)
+}
+
+// After returns a channel which receives the virtual time once the clock
+// has advanced by d
+func (self *Simulated) After(d time.Duration) <-chan time.Time {
+	c := make(chan time.Time, 1)
+	if d <= 0 {
+		c <- self.Now().Time()
+		return c
+	}
+	self.schedule(d, c, nil)
+	return c
+}
+
+// AfterFunc calls f in the go routine calling Run once the clock has advanced
+// by d (on the next Run if d <= 0)
+func (self *Simulated) AfterFunc(d time.Duration, f func()) {
+	self.schedule(d, nil, f)
+}
+
+func (self *Simulated) schedule(d time.Duration, c chan time.Time, f func()) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.init()
+	t := &simTimer{at: self.now.Add(d), seq: self.seq, c: c, f: f}
+	self.seq++
+	// keep timers sorted by due time then creation order
+	i := len(self.scheduled)
+	for i > 0 && self.scheduled[i-1].at > t.at {
+		i--
+	}
+	self.scheduled = append(self.scheduled, nil)
+	copy(self.scheduled[i+1:], self.scheduled[i:])
+	self.scheduled[i] = t
+	self.cond.Broadcast()
+}
@@ -0,0 +1,114 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package mclock
+
+import (
+	"testing"
+	"time"
+)
+
+var _ Clock = System{}
+var _ Clock = new(Simulated)
+
+func TestSimulatedAfter(t *testing.T) {
+	var c Simulated
+	later := c.After(2 * time.Hour)
+	sooner := c.After(time.Hour)
+	c.Run(90 * time.Minute)
+	select {
+	case <-sooner:
+	default:
+		t.Fatal("timer due before the end of the run did not fire")
+	}
+	select {
+	case <-later:
+		t.Fatal("timer due after the end of the run fired")
+	default:
+	}
+	if c.Now() != AbsTime(90*time.Minute) {
+		t.Fatalf("wrong time after run: %v", c.Now())
+	}
+	if n := c.ActiveTimers(); n != 1 {
+		t.Fatalf("expected 1 active timer, got %v", n)
+	}
+	c.Run(time.Hour)
+	if now := <-later; now != (time.Time{}).Add(2*time.Hour) {
+		t.Fatalf("timer fired at the wrong time: %v", now)
+	}
+}
+
+func TestSimulatedSleep(t *testing.T) {
+	var c Simulated
+	done := make(chan AbsTime)
+	go func() {
+		c.Sleep(time.Minute)
+		done <- c.Now()
+	}()
+	c.WaitForTimers(1)
+	c.Run(time.Hour)
+	if now := <-done; now != AbsTime(time.Hour) {
+		t.Fatalf("sleep returned at the wrong time: %v", now)
+	}
+}
+
+func TestTicker(t *testing.T) {
+	var c Simulated
+	ticker := NewTicker(&c, time.Second)
+	defer ticker.Stop()
+	for i := 1; i <= 3; i++ {
+		c.WaitForTimers(1)
+		c.Run(time.Second)
+		if now := <-ticker.C; now != (time.Time{}).Add(time.Duration(i)*time.Second) {
+			t.Fatalf("tick %v at the wrong time: %v", i, now)
+		}
+	}
+}
+
+func TestTickerRun(t *testing.T) {
+	var c Simulated
+	ticker := NewTicker(&c, time.Second)
+	defer ticker.Stop()
+	done := make(chan struct{})
+	go func() {
+		c.Run(1000 * time.Second)
+		close(done)
+	}()
+	for i := 1; i <= 1000; i++ {
+		if now := <-ticker.C; now != (time.Time{}).Add(time.Duration(i)*time.Second) {
+			t.Fatalf("tick %v at the wrong time: %v", i, now)
+		}
+	}
+	<-done
+	if n := c.ActiveTimers(); n != 1 {
+		t.Fatalf("expected the ticker to be rearmed, got %v active timers", n)
+	}
+}
+
+func TestSimulatedAfterFunc(t *testing.T) {
+	var c Simulated
+	var fired []AbsTime
+	var rearm func()
+	rearm = func() {
+		fired = append(fired, c.Now())
+		c.AfterFunc(time.Minute, rearm)
+	}
+	c.AfterFunc(time.Minute, rearm)
+	c.Run(time.Hour)
+	if len(fired) != 60 || fired[59] != AbsTime(time.Hour) {
+		t.Fatalf("expected 60 calls within the run, got %v", len(fired))
+	}
+}
@@ -50,11 +50,22 @@ type Subscription interface {
 //
 // The zero value is ready to use.
 type TypeMux struct {
+	// Now returns the time events are stamped with when posted,
+	// defaults to time.Now. It must not be changed once the mux is in use.
+	Now func() time.Time
+
 	mutex   sync.RWMutex
 	subm    map[reflect.Type][]*muxsub
 	stopped bool
 }
 
+func (mux *TypeMux) now() time.Time {
+	if mux.Now == nil {
+		return time.Now()
+	}
+	return mux.Now()
+}
+
 // ErrMuxClosed is returned when Posting on a closed TypeMux.
 var ErrMuxClosed = errors.New("event: mux closed")
 
@@ -63,6 +74,7 @@ var ErrMuxClosed = errors.New("event: mux closed")
 // or the mux is closed.
 func (mux *TypeMux) Subscribe(types ...interface{}) Subscription {
 	sub := newsub(mux)
+	sub.created = mux.now()
 	mux.mutex.Lock()
 	defer mux.mutex.Unlock()
 	if mux.stopped {
@@ -93,7 +105,7 @@ func (mux *TypeMux) Subscribe(types ...interface{}) Subscription {
 // It returns ErrMuxClosed if the mux has been stopped.
 func (mux *TypeMux) Post(ev interface{}) error {
 	event := &Event{
-		Time: time.Now(),
+		Time: mux.now(),
 		Data: ev,
 	}
 	rtyp := reflect.TypeOf(ev)
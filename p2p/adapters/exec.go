@@ -0,0 +1,389 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package adapters
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+// environment variable passing the node config to the child process
+const execConfigEnv = "P2P_EXEC_NODE_CONFIG"
+
+// ExecConfig configures how ExecNodes are launched
+// Command is the command line of the node process, it defaults to the
+// running binary (os.Args[0]) which then needs to call ExecInit
+// if Docker is set, the command is run in a container of the given image
+// (with the host network so that nodes can reach each other)
+// if Protocol is set, the node process runs the protocols created by the
+// factory registered under that name (see RegisterProtocol)
+type ExecConfig struct {
+	Command        []string
+	Docker         string
+	Protocol       string          `json:",omitempty"`
+	ProtocolConfig json.RawMessage `json:",omitempty"`
+}
+
+// execNodeConfig is passed to the node process
+type execNodeConfig struct {
+	PrivateKey     string
+	ListenAddr     string
+	Protocol       string          `json:",omitempty"`
+	ProtocolConfig json.RawMessage `json:",omitempty"`
+}
+
+// ExecPeerEvent reports a peer connection established or dropped by the node process
+type ExecPeerEvent struct {
+	Up      bool
+	Peer    string
+	Inbound bool
+}
+
+// ExecNode is the network adapter that runs nodes as separate OS processes
+// (or docker containers) running a p2p.Server
+// the node process is controlled over JSON-RPC on its stdin/stdout, peer
+// connections are reported back to the network through its Reporter
+type ExecNode struct {
+	Id      *NodeId
+	key     *ecdsa.PrivateKey
+	network Network
+	conf    *ExecConfig
+	lock    sync.Mutex
+	cmd     *exec.Cmd
+	client  *rpc.Client
+	addr    string
+}
+
+// NewExecNode creates an ExecNode, the node id must be derived from the key
+func NewExecNode(id *NodeId, key *ecdsa.PrivateKey, n Network, conf *ExecConfig) *ExecNode {
+	if conf == nil {
+		conf = &ExecConfig{}
+	}
+	return &ExecNode{
+		Id:      id,
+		key:     key,
+		network: n,
+		conf:    conf,
+	}
+}
+
+// command returns the command line launching the node process
+func (self *ExecNode) command() []string {
+	command := self.conf.Command
+	if len(command) == 0 && len(self.conf.Docker) == 0 {
+		command = []string{os.Args[0]}
+	}
+	if len(self.conf.Docker) > 0 {
+		command = dockerCommand(self.conf.Docker, command)
+	}
+	return command
+}
+
+// Start launches the node process and waits until its p2p.Server is running
+func (self *ExecNode) Start() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.cmd != nil {
+		return fmt.Errorf("node %v already running", self.Id)
+	}
+	if self.key == nil {
+		return fmt.Errorf("node %v has no private key", self.Id)
+	}
+	conf, err := json.Marshal(&execNodeConfig{
+		PrivateKey:     hex.EncodeToString(crypto.FromECDSA(self.key)),
+		ListenAddr:     "127.0.0.1:0",
+		Protocol:       self.conf.Protocol,
+		ProtocolConfig: self.conf.ProtocolConfig,
+	})
+	if err != nil {
+		return err
+	}
+	command := self.command()
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), execConfigEnv+"="+string(conf))
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("cannot start node %v: %v", self.Id, err)
+	}
+	client := jsonrpc.NewClient(&stdioConn{stdout, stdin})
+	var addr string
+	if err := client.Call("Node.Info", 0, &addr); err != nil {
+		client.Close()
+		cmd.Wait()
+		return fmt.Errorf("cannot get info of node %v: %v", self.Id, err)
+	}
+	self.cmd = cmd
+	self.client = client
+	self.addr = addr
+	glog.V(6).Infof("node %v running as process %v (%v)", self.Id, cmd.Process.Pid, addr)
+	go self.report(client)
+	return nil
+}
+
+// Stop stops the p2p.Server of the node process and waits for the process to exit
+func (self *ExecNode) Stop() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.cmd == nil {
+		return fmt.Errorf("node %v not running", self.Id)
+	}
+	var ok bool
+	err := self.client.Call("Node.Stop", 0, &ok)
+	self.client.Close()
+	if werr := self.cmd.Wait(); err == nil {
+		err = werr
+	}
+	self.cmd = nil
+	self.client = nil
+	return err
+}
+
+// report relays the peer events of the node process to the network
+// connections are reported by the dialling node, disconnects by either side
+// (the second report is ignored by the network)
+func (self *ExecNode) report(client *rpc.Client) {
+	for {
+		var ev ExecPeerEvent
+		if err := client.Call("Node.NextEvent", 0, &ev); err != nil {
+			glog.V(6).Infof("node %v stops reporting: %v", self.Id, err)
+			return
+		}
+		peer, err := discover.HexID(ev.Peer)
+		if err != nil {
+			glog.V(6).Infof("node %v reported invalid peer: %v", self.Id, err)
+			continue
+		}
+		id := &NodeId{peer}
+		if ev.Up {
+			if ev.Inbound {
+				continue
+			}
+			err = self.network.DidConnect(self.Id, id)
+		} else {
+			err = self.network.DidDisconnect(self.Id, id)
+		}
+		if err != nil {
+			glog.V(6).Infof("node %v report on peer %v: %v", self.Id, id, err)
+		}
+	}
+}
+
+func (self *ExecNode) call(method, addr string) error {
+	self.lock.Lock()
+	client := self.client
+	self.lock.Unlock()
+	if client == nil {
+		return fmt.Errorf("node %v not running", self.Id)
+	}
+	var ok bool
+	return client.Call(method, addr, &ok)
+}
+
+// Connect makes the node dial the peer given by its enode URL (see LocalAddr)
+func (self *ExecNode) Connect(addr []byte) error {
+	return self.call("Node.AddPeer", string(addr))
+}
+
+// Disconnect makes the node drop the peer given by its enode URL
+func (self *ExecNode) Disconnect(addr []byte) error {
+	return self.call("Node.RemovePeer", string(addr))
+}
+
+// LocalAddr returns the enode URL of the node, only available once started
+func (self *ExecNode) LocalAddr() []byte {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return []byte(self.addr)
+}
+
+func (self *ExecNode) ParseAddr(p []byte, s string) ([]byte, error) {
+	return p, nil
+}
+
+func (self *ExecNode) Messenger() Messenger {
+	return &RLPxMessenger{}
+}
+
+// stdioConn combines the stdout and stdin pipes of a process into a connection
+type stdioConn struct {
+	io.ReadCloser
+	io.WriteCloser
+}
+
+func (self *stdioConn) Close() error {
+	err := self.WriteCloser.Close()
+	if rerr := self.ReadCloser.Close(); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// ExecInit runs the node if the process was launched by an ExecNode and exits
+// when the node is stopped, otherwise it returns immediately
+// binaries used as ExecNode command must call it at the start of main (or
+// TestMain), protocols are the protocols run by the node unless the ExecNode
+// selects a registered protocol
+func ExecInit(protocols ...p2p.Protocol) {
+	env := os.Getenv(execConfigEnv)
+	if len(env) == 0 {
+		return
+	}
+	if err := runExecNode(env, protocols); err != nil {
+		fmt.Fprintf(os.Stderr, "node failed: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// runExecNode starts a p2p.Server and serves the control API on stdin/stdout
+// until the controlling ExecNode closes stdin
+func runExecNode(env string, protocols []p2p.Protocol) error {
+	var conf execNodeConfig
+	if err := json.Unmarshal([]byte(env), &conf); err != nil {
+		return fmt.Errorf("invalid config: %v", err)
+	}
+	key, err := crypto.HexToECDSA(conf.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("invalid private key: %v", err)
+	}
+	if len(conf.Protocol) > 0 {
+		id := &NodeId{discover.PubkeyID(&key.PublicKey)}
+		protocols, err = NewProtocols(conf.Protocol, id, conf.ProtocolConfig)
+		if err != nil {
+			return err
+		}
+	}
+	service := &execService{
+		events: make(chan *ExecPeerEvent, 1024),
+		quitc:  make(chan struct{}),
+	}
+	srv := &p2p.Server{
+		Config: p2p.Config{
+			PrivateKey: key,
+			MaxPeers:   1024,
+			ListenAddr: conf.ListenAddr,
+			Protocols:  protocols,
+			Name:       Name(crypto.FromECDSAPub(&key.PublicKey)[1:]),
+		},
+	}
+	srv.PeerConnHook = func(p *p2p.Peer) {
+		service.post(&ExecPeerEvent{Up: true, Peer: p.ID().String(), Inbound: p.Inbound()})
+	}
+	srv.PeerDisconnHook = func(p *p2p.Peer) {
+		service.post(&ExecPeerEvent{Up: false, Peer: p.ID().String(), Inbound: p.Inbound()})
+	}
+	if err := srv.Start(); err != nil {
+		return err
+	}
+	service.srv = srv
+	server := rpc.NewServer()
+	if err := server.RegisterName("Node", service); err != nil {
+		return err
+	}
+	server.ServeCodec(jsonrpc.NewServerCodec(&stdioConn{os.Stdin, os.Stdout}))
+	service.stop()
+	return nil
+}
+
+// execService is the control API of the node process
+type execService struct {
+	srv      *p2p.Server
+	events   chan *ExecPeerEvent
+	quitc    chan struct{}
+	stopOnce sync.Once
+}
+
+func (self *execService) post(ev *ExecPeerEvent) {
+	select {
+	case self.events <- ev:
+	case <-self.quitc:
+	}
+}
+
+func (self *execService) stop() {
+	self.stopOnce.Do(func() {
+		close(self.quitc)
+		self.srv.Stop()
+	})
+}
+
+// Info returns the enode URL of the node
+func (self *execService) Info(arg int, addr *string) error {
+	*addr = self.srv.Self().String()
+	return nil
+}
+
+// AddPeer connects to the node given by its enode URL
+func (self *execService) AddPeer(url string, ok *bool) error {
+	node, err := discover.ParseNode(url)
+	if err != nil {
+		return fmt.Errorf("invalid node URL: %v", err)
+	}
+	self.srv.AddPeer(node)
+	*ok = true
+	return nil
+}
+
+// RemovePeer disconnects from the node given by its enode URL
+func (self *execService) RemovePeer(url string, ok *bool) error {
+	node, err := discover.ParseNode(url)
+	if err != nil {
+		return fmt.Errorf("invalid node URL: %v", err)
+	}
+	self.srv.RemovePeer(node)
+	*ok = true
+	return nil
+}
+
+// NextEvent blocks until the next peer event, it errors once the node is stopped
+func (self *execService) NextEvent(arg int, ev *ExecPeerEvent) error {
+	select {
+	case e := <-self.events:
+		*ev = *e
+		return nil
+	case <-self.quitc:
+		return fmt.Errorf("node stopped")
+	}
+}
+
+// Stop stops the p2p.Server of the node
+func (self *execService) Stop(arg int, ok *bool) error {
+	self.stop()
+	*ok = true
+	return nil
+}
@@ -0,0 +1,144 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package adapters
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+)
+
+// the test binary doubles as the node process of ExecNodes
+func TestMain(m *testing.M) {
+	ExecInit()
+	os.Exit(m.Run())
+}
+
+type reportEvent struct {
+	up         bool
+	one, other *NodeId
+}
+
+// testNetwork records the connections reported by the nodes
+type testNetwork struct {
+	events chan *reportEvent
+}
+
+func (self *testNetwork) GetNodeAdapter(id *NodeId) NodeAdapter {
+	return nil
+}
+
+func (self *testNetwork) DidConnect(one, other *NodeId) error {
+	self.events <- &reportEvent{true, one, other}
+	return nil
+}
+
+func (self *testNetwork) DidDisconnect(one, other *NodeId) error {
+	self.events <- &reportEvent{false, one, other}
+	return nil
+}
+
+func newTestExecNode(t *testing.T, net Network) *ExecNode {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := NewNodeId(crypto.FromECDSAPub(&key.PublicKey)[1:])
+	node := NewExecNode(id, key, net, nil)
+	if err := node.Start(); err != nil {
+		t.Fatalf("unexpected error starting node: %v", err)
+	}
+	return node
+}
+
+func TestExecNode(t *testing.T) {
+	net := &testNetwork{events: make(chan *reportEvent, 10)}
+	one := newTestExecNode(t, net)
+	defer one.Stop()
+	other := newTestExecNode(t, net)
+	defer other.Stop()
+
+	expect := func(up bool) {
+		select {
+		case ev := <-net.events:
+			if ev.up != up {
+				t.Fatalf("expected connection up: %v, got %v", up, ev.up)
+			}
+			if ev.one.NodeID != one.Id.NodeID && ev.one.NodeID != other.Id.NodeID {
+				t.Fatalf("unexpected node reporting: %v", ev.one)
+			}
+			if up && (ev.one.NodeID != one.Id.NodeID || ev.other.NodeID != other.Id.NodeID) {
+				t.Fatalf("expected connection reported by the dialling node, got %v -> %v", ev.one, ev.other)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for connection up: %v", up)
+		}
+	}
+	if err := one.Connect(other.LocalAddr()); err != nil {
+		t.Fatalf("unexpected error connecting nodes: %v", err)
+	}
+	expect(true)
+	if err := one.Disconnect(other.LocalAddr()); err != nil {
+		t.Fatalf("unexpected error disconnecting nodes: %v", err)
+	}
+	expect(false)
+}
+
+// the protocol is registered in init so that it is also available in the
+// node processes
+func init() {
+	RegisterProtocol("exectest", func(id *NodeId, conf json.RawMessage) ([]p2p.Protocol, error) {
+		if string(conf) != `{"fail":false}` {
+			return nil, fmt.Errorf("unexpected config %s", conf)
+		}
+		return []p2p.Protocol{{Name: "exectest", Version: 1, Length: 1, Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			_, err := rw.ReadMsg()
+			return err
+		}}}, nil
+	})
+}
+
+func TestExecProtocol(t *testing.T) {
+	net := &testNetwork{events: make(chan *reportEvent, 10)}
+	for _, test := range []struct {
+		conf *ExecConfig
+		ok   bool
+	}{
+		{&ExecConfig{Protocol: "exectest", ProtocolConfig: json.RawMessage(`{"fail":false}`)}, true},
+		{&ExecConfig{Protocol: "exectest", ProtocolConfig: json.RawMessage(`{"fail":true}`)}, false},
+		{&ExecConfig{Protocol: "unknown"}, false},
+	} {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := NewNodeId(crypto.FromECDSAPub(&key.PublicKey)[1:])
+		node := NewExecNode(id, key, net, test.conf)
+		err = node.Start()
+		if test.ok != (err == nil) {
+			t.Fatalf("protocol %v: unexpected result starting node: %v", test.conf.Protocol, err)
+		}
+		if err == nil {
+			node.Stop()
+		}
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/discover"
@@ -55,6 +56,9 @@ type SimNode struct {
 	peerMap   map[discover.NodeID]int
 	peers     []*Peer
 	Run       ProtoCall
+	// message tracing (see Trace)
+	eventer *event.TypeMux
+	msgName func(uint64) string
 }
 
 func (self *SimNode) Messenger() Messenger {
@@ -70,6 +74,15 @@ func NewSimNode(id *NodeId, n Network, m Messenger) *SimNode {
 	}
 }
 
+// Trace switches on tracing of the messages the node sends to its peers,
+// a MsgEvent is posted to eventer for each message (see TracingMsgReadWriter)
+func (self *SimNode) Trace(eventer *event.TypeMux, name func(uint64) string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.eventer = eventer
+	self.msgName = name
+}
+
 func (self *SimNode) LocalAddr() []byte {
 	return self.Id.Bytes()
 }
@@ -107,32 +120,52 @@ func (self *SimNode) setPeer(id *NodeId, rw p2p.MsgReadWriter) *Peer {
 		self.peers = append(self.peers, p)
 		return p
 	}
-	if self.peers[i] != nil && rw != nil {
+	p := self.peers[i]
+	if p.RW != nil && rw != nil {
 		panic(fmt.Sprintf("pipe for %v already set", id))
 	}
 	// legit reconnect reset disconnection error,
-	p := self.peers[i]
+	if rw != nil {
+		p.Errc = make(chan error, 1)
+	}
 	p.RW = rw
 	return p
 }
 
 func (self *SimNode) Disconnect(rid []byte) error {
 	self.lock.Lock()
-	defer self.lock.Unlock()
 	id := NewNodeId(rid)
 	peer := self.getPeer(id)
 	if peer == nil || peer.RW == nil {
+		self.lock.Unlock()
 		return fmt.Errorf("already disconnected")
 	}
 	peer.RW.(*p2p.MsgPipeRW).Close()
 	peer.RW = nil
-	// na := self.network.GetNodeAdapter(id)
-	// peer = na.(*SimNode).GetPeer(self.Id)
-	// peer.RW = nil
+	self.lock.Unlock()
 	glog.V(6).Infof("dropped peer %v", id)
+	// closing the pipe closes both ends, the remote node's peer entry is reset
+	// here. If the remote has already done so, it has reported the disconnect
+	if na, ok := self.network.GetNodeAdapter(id).(*SimNode); ok {
+		if !na.dropPeer(self.Id) {
+			return nil
+		}
+	}
 	return self.network.DidDisconnect(self.Id, id)
 }
 
+// dropPeer resets the pipe of the peer, returns false if it was already reset
+func (self *SimNode) dropPeer(id *NodeId) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	peer := self.getPeer(id)
+	if peer == nil || peer.RW == nil {
+		return false
+	}
+	peer.RW = nil
+	return true
+}
+
 func (self *SimNode) Connect(rid []byte) error {
 	self.lock.Lock()
 	defer self.lock.Unlock()
@@ -144,14 +177,19 @@ func (self *SimNode) Connect(rid []byte) error {
 	rw, rrw := p2p.MsgPipe()
 	runc := make(chan bool)
 	defer close(runc)
+	// messages written by the protocols are shaped if the network models links
+	var link *LinkProfile
+	if ln, ok := self.network.(LinkNetwork); ok {
+		link = ln.Link(self.Id, id)
+	}
 	// run protocol on remote node with self as peer
 
-	err := na.(*SimNode).runProtocol(self.Id, rrw, rw, runc)
+	err := na.(*SimNode).runProtocol(self.Id, rrw, rw, link, 1, runc)
 	if err != nil {
 		return fmt.Errorf("cannot run protocol (%v -> %v) %v", self.Id, id, err)
 	}
 	// run protocol on remote node with self as peer
-	err = self.runProtocol(id, rw, rrw, runc)
+	err = self.runProtocol(id, rw, rrw, link, 0, runc)
 	if err != nil {
 		return fmt.Errorf("cannot run protocol (%v -> %v): %v", id, self.Id, err)
 	}
@@ -159,20 +197,34 @@ func (self *SimNode) Connect(rid []byte) error {
 	return nil
 }
 
-func (self *SimNode) runProtocol(id *NodeId, rw, rrw p2p.MsgReadWriter, runc chan bool) error {
+// runProtocol runs the protocol on rw, the messages written are traced once
+// they are delivered by the link, so messages lost are not traced
+// seed distinguishes the random decisions of the two directions of the link
+func (self *SimNode) runProtocol(id *NodeId, rw, rrw p2p.MsgReadWriter, link *LinkProfile, seed int64, runc chan bool) error {
+	peer := self.getPeer(id)
+	if peer != nil && peer.RW != nil {
+		return fmt.Errorf("already connected %v to peer %v", self.Id, id)
+	}
+	peer = self.setPeer(id, rrw)
 	if self.Run == nil {
 		glog.V(6).Infof("no protocol starting on peer %v (connection with %v)", self.Id, id)
 		return nil
 	}
 	glog.V(6).Infof("protocol starting on peer %v (connection with %v)", self.Id, id)
-	peer := self.getPeer(id)
-	if peer != nil && peer.RW != nil {
-		return fmt.Errorf("already connected %v to peer %v", self.Id, id)
+	if self.eventer != nil {
+		rw = NewTracingMsgReadWriter(rw, self.Id, id, self.eventer, self.msgName)
+	}
+	var shaper *ShapingMsgReadWriter
+	if link != nil {
+		shaper = NewShapingMsgReadWriter(rw, link, link.Seed+seed, networkClock(self.network))
+		rw = shaper
 	}
-	peer = self.setPeer(id, rrw)
 	p := p2p.NewPeer(id.NodeID, Name(id.Bytes()), []p2p.Cap{})
 	go func() {
 		err := self.Run(p, rw)
+		if shaper != nil {
+			shaper.Close()
+		}
 		glog.V(6).Infof("protocol quit on peer %v (connection with %v broken)", self.Id, id)
 		<-runc
 		self.Disconnect(id.Bytes())
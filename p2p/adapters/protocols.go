@@ -0,0 +1,94 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package adapters
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/p2p"
+)
+
+// ProtocolFactory creates the protocols run by a node from a protocol specific
+// JSON config, it is called for every node so that protocol instances can keep
+// per node state
+type ProtocolFactory func(id *NodeId, conf json.RawMessage) ([]p2p.Protocol, error)
+
+var (
+	protocolsLock sync.RWMutex
+	protocols     = make(map[string]ProtocolFactory)
+	msgNames      = make(map[string]func(uint64) string)
+)
+
+// RegisterProtocol makes a protocol factory available under the given name
+// it is meant to be called from init functions so that the protocol is also
+// available in node processes launched by ExecNode
+func RegisterProtocol(name string, f ProtocolFactory) {
+	protocolsLock.Lock()
+	defer protocolsLock.Unlock()
+	protocols[name] = f
+}
+
+// UnregisterProtocol removes the protocol factory registered under the given name
+func UnregisterProtocol(name string) {
+	protocolsLock.Lock()
+	defer protocolsLock.Unlock()
+	delete(protocols, name)
+	delete(msgNames, name)
+}
+
+// RegisterMsgNames sets the function naming the message codes of the protocol
+// registered under the given name (e.g., the TypeName method of the
+// protocols.CodeMap of the protocol), messages traced on nodes running the
+// protocol are labelled with the names (see MsgEvent)
+func RegisterMsgNames(name string, f func(code uint64) string) {
+	protocolsLock.Lock()
+	defer protocolsLock.Unlock()
+	msgNames[name] = f
+}
+
+// MsgNames returns the function naming the message codes of the protocol
+// registered under the given name, nil if not set
+func MsgNames(name string) func(code uint64) string {
+	protocolsLock.RLock()
+	defer protocolsLock.RUnlock()
+	return msgNames[name]
+}
+
+// HasProtocol tells if a protocol factory is registered under the given name
+func HasProtocol(name string) bool {
+	protocolsLock.RLock()
+	defer protocolsLock.RUnlock()
+	_, ok := protocols[name]
+	return ok
+}
+
+// NewProtocols creates the protocols of the node with the factory registered
+// under the given name, an empty name means no protocols
+func NewProtocols(name string, id *NodeId, conf json.RawMessage) ([]p2p.Protocol, error) {
+	if len(name) == 0 {
+		return nil, nil
+	}
+	protocolsLock.RLock()
+	f, ok := protocols[name]
+	protocolsLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown protocol '%v'", name)
+	}
+	return f(id, conf)
+}
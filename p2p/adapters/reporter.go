@@ -17,28 +17,60 @@
 package adapters
 
 import (
-// "fmt"
-// "net"
-
-// "github.com/ethereum/go-ethereum/p2p"
-// "github.com/ethereum/go-ethereum/p2p/discover"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
 )
 
+// ConnReport is the report of a connection established or dropped, the caller
+// is the node that dialled (or dropped) the connection, the time is when the
+// node observed the change on its own clock
+type ConnReport struct {
+	Up     bool      `json:"up"`
+	Caller *NodeId   `json:"caller"`
+	Callee *NodeId   `json:"callee"`
+	Time   time.Time `json:"time"`
+}
+
+// RemoteReporter is a Reporter that POSTs ConnReports as JSON to a URL,
+// typically the report resource of a network controller (/<networkId>/report)
+// used by nodes running outside the simulation to keep the network model accurate
 type RemoteReporter struct {
+	url    string
+	client *http.Client
 }
 
 func NewRemoteReporter(url string) *RemoteReporter {
-	return &RemoteReporter{}
+	return &RemoteReporter{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
 }
 
-func (self *RemoteReporter) DidConnect(source, target *NodeId) {
-	self.post(true)
+func (self *RemoteReporter) DidConnect(source, target *NodeId) error {
+	return self.post(&ConnReport{Up: true, Caller: source, Callee: target, Time: time.Now()})
 }
 
-func (self *RemoteReporter) DidDisconnect(source, target *NodeId) {
-	self.post(true)
+func (self *RemoteReporter) DidDisconnect(source, target *NodeId) error {
+	return self.post(&ConnReport{Up: false, Caller: source, Callee: target, Time: time.Now()})
 }
 
-func (self *RemoteReporter) post(interface{}) {
-
+func (self *RemoteReporter) post(report *ConnReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	resp, err := self.client.Post(self.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("report rejected: %v: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
 }
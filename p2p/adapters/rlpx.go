@@ -20,12 +20,16 @@ import (
 	"fmt"
 	"net"
 
+	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/discover"
 )
 
 // devp2p RLPx underlay support
 
+// RLPx is the network adapter that runs a p2p.Server, nodes communicate over
+// real encrypted TCP connections, peers are addressed by enode URL
+// the server listens on a random loopback port unless configured otherwise
 type RLPx struct {
 	id   *NodeId
 	net  *p2p.Server
@@ -41,21 +45,41 @@ func NewRLPx(addr []byte, srv *p2p.Server, m Messenger) *RLPx {
 	if m == nil {
 		m = &RLPxMessenger{}
 	}
+	if len(srv.ListenAddr) == 0 {
+		srv.ListenAddr = "127.0.0.1:0"
+	}
+	if srv.MaxPeers == 0 {
+		srv.MaxPeers = 1024
+	}
+	var id *NodeId
+	if srv.PrivateKey != nil {
+		id = &NodeId{discover.PubkeyID(&srv.PrivateKey.PublicKey)}
+	}
 	return &RLPx{
+		id:   id,
 		net:  srv,
 		addr: addr,
 		m:    m,
 	}
 }
 
+// NewReportingRLPx creates an RLPx adapter reporting the connections of its server
+// connections are reported by the dialling node, disconnects by either side
 func NewReportingRLPx(addr []byte, srv *p2p.Server, m Messenger, r Reporter) *RLPx {
 	rlpx := NewRLPx(addr, srv, m)
 	rlpx.r = r
 	srv.PeerConnHook = func(p *p2p.Peer) {
-		r.DidConnect(rlpx.id, &NodeId{p.ID()})
+		if p.Inbound() {
+			return
+		}
+		if err := r.DidConnect(rlpx.id, &NodeId{p.ID()}); err != nil {
+			glog.V(6).Infof("node %v report on peer %v: %v", rlpx.id, p.ID(), err)
+		}
 	}
 	srv.PeerDisconnHook = func(p *p2p.Peer) {
-		r.DidDisconnect(rlpx.id, &NodeId{p.ID()})
+		if err := r.DidDisconnect(rlpx.id, &NodeId{p.ID()}); err != nil {
+			glog.V(6).Infof("node %v report on peer %v: %v", rlpx.id, p.ID(), err)
+		}
 	}
 	return rlpx
 }
@@ -68,8 +92,24 @@ func (*RLPxMessenger) ReadMsg(r p2p.MsgReader) (p2p.Msg, error) {
 	return r.ReadMsg()
 }
 
+// Start starts the server of the node
+func (self *RLPx) Start() error {
+	return self.net.Start()
+}
+
+// Stop stops the server of the node
+func (self *RLPx) Stop() error {
+	self.net.Stop()
+	return nil
+}
+
+// LocalAddr returns the enode URL of the node once the server is started
 func (self *RLPx) LocalAddr() []byte {
-	return self.addr
+	node := self.net.Self()
+	if node.TCP == 0 {
+		return self.addr
+	}
+	return []byte(node.String())
 }
 
 func (self *RLPx) Connect(enode []byte) error {
@@ -87,8 +127,13 @@ func (self *RLPx) Messenger() Messenger {
 	return self.m
 }
 
-func (self *RLPx) Disconnect(p *p2p.Peer, rw p2p.MsgReadWriter) error {
-	p.Disconnect(p2p.DiscSubprotocolError)
+// Disconnect drops the peer given by its enode URL (and stops redialling it)
+func (self *RLPx) Disconnect(enode []byte) error {
+	node, err := discover.ParseNode(string(enode))
+	if err != nil {
+		return fmt.Errorf("invalid node URL: %v", err)
+	}
+	self.net.RemovePeer(node)
 	return nil
 }
 
@@ -0,0 +1,70 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package adapters
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+)
+
+var _ NodeAdapter = &RLPx{}
+var _ StartAdapter = &RLPx{}
+
+func newTestRLPx(t *testing.T, r Reporter) *RLPx {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := NewReportingRLPx(nil, &p2p.Server{Config: p2p.Config{PrivateKey: key}}, nil, r)
+	if err := node.Start(); err != nil {
+		t.Fatalf("unexpected error starting node: %v", err)
+	}
+	return node
+}
+
+func TestRLPx(t *testing.T) {
+	net := &testNetwork{events: make(chan *reportEvent, 10)}
+	one := newTestRLPx(t, net)
+	defer one.Stop()
+	other := newTestRLPx(t, net)
+	defer other.Stop()
+
+	expect := func(up bool) {
+		select {
+		case ev := <-net.events:
+			if ev.up != up {
+				t.Fatalf("expected connection up: %v, got %v", up, ev.up)
+			}
+			if up && (ev.one.NodeID != one.id.NodeID || ev.other.NodeID != other.id.NodeID) {
+				t.Fatalf("expected connection reported by the dialling node, got %v -> %v", ev.one, ev.other)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for connection up: %v", up)
+		}
+	}
+	if err := one.Connect(other.LocalAddr()); err != nil {
+		t.Fatalf("unexpected error connecting nodes: %v", err)
+	}
+	expect(true)
+	if err := one.Disconnect(other.LocalAddr()); err != nil {
+		t.Fatalf("unexpected error disconnecting nodes: %v", err)
+	}
+	expect(false)
+}
@@ -0,0 +1,258 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package adapters
+
+import (
+	"bytes"
+	"container/heap"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/p2p"
+)
+
+// LinkProfile describes the quality of a simulated link
+// the same profile applies to both directions of a connection
+// durations are given in nanoseconds when JSON encoded
+type LinkProfile struct {
+	Latency      time.Duration `json:"latency"`      // mean one-way latency
+	Jitter       time.Duration `json:"jitter"`       // deviation of the latency
+	Distribution string        `json:"distribution"` // of the latency: "uniform" (default), "normal" or "exponential"
+	Bandwidth    int           `json:"bandwidth"`    // in bytes per second, 0 means unlimited
+	Loss         float64       `json:"loss"`         // probability of a message being dropped
+	Reorder      float64       `json:"reorder"`      // probability of a message being overtaken by later ones
+	Seed         int64         `json:"seed"`         // seed of random decisions
+}
+
+// LinkNetwork is implemented by networks that model the links between nodes
+// Link returns the profile of the link between the two nodes (nil for perfect links)
+type LinkNetwork interface {
+	Link(one, other *NodeId) *LinkProfile
+}
+
+// ClockNetwork is implemented by networks that do not run on the system clock
+// (see mclock.Simulated), timers of the nodes use the clock of the network
+type ClockNetwork interface {
+	Clock() mclock.Clock
+}
+
+// networkClock returns the clock of the network, the system clock by default
+func networkClock(network Network) mclock.Clock {
+	if cn, ok := network.(ClockNetwork); ok {
+		return cn.Clock()
+	}
+	return mclock.System{}
+}
+
+// latency draws the latency of a message
+func (self *LinkProfile) latency(r *rand.Rand) time.Duration {
+	var d float64
+	switch self.Distribution {
+	case "normal":
+		d = float64(self.Latency) + r.NormFloat64()*float64(self.Jitter)
+	case "exponential":
+		d = float64(self.Latency) + r.ExpFloat64()*float64(self.Jitter)
+	default:
+		d = float64(self.Latency) + (2*r.Float64()-1)*float64(self.Jitter)
+	}
+	if d < 0 {
+		return 0
+	}
+	return time.Duration(d)
+}
+
+// ShapingMsgReadWriter wraps a MsgReadWriter and applies a LinkProfile to the
+// messages written to it: messages are delayed, dropped or reordered
+// writes do not block, messages are delivered by a separate go routine
+// random decisions only depend on the seed and the sequence of messages written
+// so the same exchange of messages is shaped the same way on each run
+type ShapingMsgReadWriter struct {
+	p2p.MsgReadWriter
+	profile *LinkProfile
+	clock   mclock.Clock
+	rand    *rand.Rand
+	once    sync.Once
+	lock    sync.Mutex
+	queue   shapedMsgs
+	seq     int
+	busy    mclock.AbsTime // link busy transmitting until
+	last    mclock.AbsTime // due time of the last message delivered in order
+	err     error
+	wakec   chan struct{}
+	quitc   chan struct{}
+}
+
+// NewShapingMsgReadWriter returns a MsgReadWriter shaping messages written to rw
+// delays are measured on the clock given
+func NewShapingMsgReadWriter(rw p2p.MsgReadWriter, profile *LinkProfile, seed int64, clock mclock.Clock) *ShapingMsgReadWriter {
+	return &ShapingMsgReadWriter{
+		MsgReadWriter: rw,
+		profile:       profile,
+		clock:         clock,
+		rand:          rand.New(rand.NewSource(seed)),
+		wakec:         make(chan struct{}, 1),
+		quitc:         make(chan struct{}),
+	}
+}
+
+// WriteMsg schedules the delivery of the message
+func (self *ShapingMsgReadWriter) WriteMsg(msg p2p.Msg) error {
+	payload, err := ioutil.ReadAll(msg.Payload)
+	if err != nil {
+		return err
+	}
+	self.once.Do(func() { go self.deliver() })
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.err != nil {
+		return self.err
+	}
+	// all random values are drawn for each message to keep decisions reproducible
+	lost := self.rand.Float64() < self.profile.Loss
+	reordered := self.rand.Float64() < self.profile.Reorder
+	latency := self.profile.latency(self.rand)
+	if lost {
+		glog.V(6).Infof("message %v lost", msg.Code)
+		return nil
+	}
+	now := self.clock.Now()
+	start := self.busy
+	if start < now {
+		start = now
+	}
+	self.busy = start
+	if self.profile.Bandwidth > 0 {
+		self.busy = start.Add(time.Duration(len(payload)) * time.Second / time.Duration(self.profile.Bandwidth))
+	}
+	due := self.busy.Add(latency)
+	if reordered {
+		// held back so that later messages overtake it
+		due = due.Add(self.profile.Latency + self.profile.Jitter)
+	} else {
+		if due < self.last {
+			due = self.last
+		}
+		self.last = due
+	}
+	msg.Payload = bytes.NewReader(payload)
+	heap.Push(&self.queue, &shapedMsg{msg: msg, due: due, seq: self.seq})
+	self.seq++
+	select {
+	case self.wakec <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// ReadMsg reads from the underlying MsgReadWriter, delivery stops when the
+// underlying MsgReadWriter is closed
+func (self *ShapingMsgReadWriter) ReadMsg() (p2p.Msg, error) {
+	msg, err := self.MsgReadWriter.ReadMsg()
+	if err != nil {
+		self.close(err)
+	}
+	return msg, err
+}
+
+// Close stops the delivery of messages, messages not yet delivered are dropped
+// and further writes fail, it is called once the protocol using the
+// MsgReadWriter returns
+func (self *ShapingMsgReadWriter) Close() {
+	self.close(p2p.ErrPipeClosed)
+}
+
+func (self *ShapingMsgReadWriter) close(err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.err == nil {
+		self.err = err
+		close(self.quitc)
+	}
+}
+
+// deliver writes queued messages to the underlying MsgReadWriter when they are due
+func (self *ShapingMsgReadWriter) deliver() {
+	// the timer is only renewed if the next message is due at a different
+	// time, so that there is one pending timer for each due time
+	var timerc <-chan time.Time
+	var timerDue mclock.AbsTime
+	for {
+		self.lock.Lock()
+		var next *shapedMsg
+		var wait time.Duration
+		if len(self.queue) > 0 {
+			next = self.queue[0]
+			wait = next.due.Sub(self.clock.Now())
+			if wait <= 0 {
+				heap.Pop(&self.queue)
+			}
+		}
+		self.lock.Unlock()
+		if next != nil && wait <= 0 {
+			if err := self.MsgReadWriter.WriteMsg(next.msg); err != nil {
+				self.close(err)
+				return
+			}
+			continue
+		}
+		if next != nil && (timerc == nil || timerDue != next.due) {
+			timerc = self.clock.After(wait)
+			timerDue = next.due
+		}
+		select {
+		case <-timerc:
+			timerc = nil
+		case <-self.wakec:
+		case <-self.quitc:
+			return
+		}
+	}
+}
+
+type shapedMsg struct {
+	msg p2p.Msg
+	due mclock.AbsTime
+	seq int
+}
+
+// shapedMsgs is a priority queue of messages ordered by due time
+type shapedMsgs []*shapedMsg
+
+func (self shapedMsgs) Len() int { return len(self) }
+
+func (self shapedMsgs) Less(i, j int) bool {
+	if self[i].due == self[j].due {
+		return self[i].seq < self[j].seq
+	}
+	return self[i].due < self[j].due
+}
+
+func (self shapedMsgs) Swap(i, j int) { self[i], self[j] = self[j], self[i] }
+
+func (self *shapedMsgs) Push(x interface{}) { *self = append(*self, x.(*shapedMsg)) }
+
+func (self *shapedMsgs) Pop() interface{} {
+	old := *self
+	n := len(old)
+	x := old[n-1]
+	*self = old[:n-1]
+	return x
+}
@@ -0,0 +1,121 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package adapters
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/p2p"
+)
+
+// shaped sends n messages through a shaped pipe and returns the codes in the
+// order received and the time it took to receive them
+func shaped(t *testing.T, profile *LinkProfile, n int) ([]uint64, time.Duration) {
+	rw, rrw := p2p.MsgPipe()
+	defer rw.Close()
+	srw := NewShapingMsgReadWriter(rw, profile, profile.Seed, mclock.System{})
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		if err := p2p.Send(srw, uint64(i), []uint{uint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var codes []uint64
+	msgc := make(chan p2p.Msg)
+	go func() {
+		for {
+			msg, err := rrw.ReadMsg()
+			if err != nil {
+				return
+			}
+			msg.Discard()
+			msgc <- msg
+		}
+	}()
+	timeout := time.After(500 * time.Millisecond)
+	for len(codes) < n {
+		select {
+		case msg := <-msgc:
+			codes = append(codes, msg.Code)
+		case <-timeout:
+			return codes, time.Since(start)
+		}
+	}
+	return codes, time.Since(start)
+}
+
+func TestShapingLatency(t *testing.T) {
+	codes, elapsed := shaped(t, &LinkProfile{Latency: 50 * time.Millisecond}, 3)
+	if len(codes) != 3 {
+		t.Fatalf("expected 3 messages, got %v", len(codes))
+	}
+	for i, code := range codes {
+		if code != uint64(i) {
+			t.Fatalf("expected messages in order, got %v", codes)
+		}
+	}
+	if elapsed < 50*time.Millisecond {
+		t.Fatalf("expected messages to be delayed by 50ms, took %v", elapsed)
+	}
+}
+
+func TestShapingLoss(t *testing.T) {
+	codes, _ := shaped(t, &LinkProfile{Loss: 1}, 3)
+	if len(codes) != 0 {
+		t.Fatalf("expected all messages lost, got %v", codes)
+	}
+}
+
+func TestShapingReproducible(t *testing.T) {
+	profile := &LinkProfile{Latency: 10 * time.Millisecond, Jitter: 5 * time.Millisecond, Loss: 0.3, Reorder: 0.3, Seed: 42}
+	first, _ := shaped(t, profile, 20)
+	second, _ := shaped(t, profile, 20)
+	if len(first) != len(second) {
+		t.Fatalf("expected same messages delivered, got %v and %v", first, second)
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("expected same order of messages, got %v and %v", first, second)
+		}
+	}
+}
+
+func TestShapingClose(t *testing.T) {
+	rw, rrw := p2p.MsgPipe()
+	defer rw.Close()
+	srw := NewShapingMsgReadWriter(rw, &LinkProfile{Latency: 50 * time.Millisecond}, 0, mclock.System{})
+	if err := p2p.Send(srw, 0, []uint{0}); err != nil {
+		t.Fatal(err)
+	}
+	srw.Close()
+	if err := p2p.Send(srw, 1, []uint{1}); err != p2p.ErrPipeClosed {
+		t.Fatalf("expected %v writing after close, got %v", p2p.ErrPipeClosed, err)
+	}
+	msgc := make(chan p2p.Msg, 1)
+	go func() {
+		if msg, err := rrw.ReadMsg(); err == nil {
+			msgc <- msg
+		}
+	}()
+	select {
+	case msg := <-msgc:
+		t.Fatalf("unexpected message %v delivered after close", msg.Code)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package adapters
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/p2p"
+)
+
+// MsgEvent is posted for every message a node sends to a peer
+// the time of delivery is the time of the event.Event, messages lost on a
+// simulated link (see LinkProfile) are not posted
+type MsgEvent struct {
+	Type string  `json:"type"`
+	From *NodeId `json:"from"`
+	To   *NodeId `json:"to"`
+	Code uint64  `json:"code"`
+	Size uint32  `json:"size"`
+	Name string  `json:"name,omitempty"` // name of the message type if known
+}
+
+func (self *MsgEvent) String() string {
+	return fmt.Sprintf("<Type: %v, Data: %v->%v %v(%v) %v bytes>\n", self.Type, self.From.Label(), self.To.Label(), self.Name, self.Code, self.Size)
+}
+
+// TracingMsgReadWriter wraps a MsgReadWriter and posts a MsgEvent to the eventer
+// for every message written to it
+type TracingMsgReadWriter struct {
+	p2p.MsgReadWriter
+	from, to *NodeId
+	eventer  *event.TypeMux
+	name     func(uint64) string
+}
+
+// NewTracingMsgReadWriter returns a tracing MsgReadWriter for messages sent
+// from node from to node to, name is an optional function returning the name of
+// the message type for a code (see protocols.CodeMap#TypeName)
+func NewTracingMsgReadWriter(rw p2p.MsgReadWriter, from, to *NodeId, eventer *event.TypeMux, name func(uint64) string) *TracingMsgReadWriter {
+	return &TracingMsgReadWriter{
+		MsgReadWriter: rw,
+		from:          from,
+		to:            to,
+		eventer:       eventer,
+		name:          name,
+	}
+}
+
+// WriteMsg writes the message and posts the MsgEvent once it is delivered
+func (self *TracingMsgReadWriter) WriteMsg(msg p2p.Msg) error {
+	err := self.MsgReadWriter.WriteMsg(msg)
+	if err != nil {
+		return err
+	}
+	ev := &MsgEvent{
+		Type: "msg",
+		From: self.from,
+		To:   self.to,
+		Code: msg.Code,
+		Size: msg.Size,
+	}
+	if self.name != nil {
+		ev.Name = self.name(msg.Code)
+	}
+	if err := self.eventer.Post(ev); err != nil {
+		glog.V(6).Infof("cannot post msg event %v: %v", ev, err)
+	}
+	return nil
+}
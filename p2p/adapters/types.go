@@ -17,6 +17,8 @@
 package adapters
 
 import (
+	"encoding/json"
+
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/discover"
 )
@@ -49,7 +51,10 @@ func (self *NodeId) MarshalJSON() (out []byte, err error) {
 }
 
 func (self *NodeId) UnmarshalJSON(value []byte) error {
-	s := string(value)
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return err
+	}
 	h, err := discover.HexID(s)
 	if err != nil {
 		return err
@@ -273,11 +273,11 @@ func (t *dialTask) resolve(srv *Server) bool {
 	if t.resolveDelay == 0 {
 		t.resolveDelay = initialResolveDelay
 	}
-	if time.Since(t.lastResolved) < t.resolveDelay {
+	if srv.now().Sub(t.lastResolved) < t.resolveDelay {
 		return false
 	}
 	resolved := srv.ntab.Resolve(t.dest.ID)
-	t.lastResolved = time.Now()
+	t.lastResolved = srv.now()
 	if resolved == nil {
 		t.resolveDelay *= 2
 		if t.resolveDelay > maxResolveDelay {
@@ -316,10 +316,10 @@ func (t *discoverTask) Do(srv *Server) {
 	// necessary. Lookups need to take some time, otherwise the
 	// event loop spins too fast.
 	next := srv.lastLookup.Add(lookupInterval)
-	if now := time.Now(); now.Before(next) {
-		time.Sleep(next.Sub(now))
+	if now := srv.now(); now.Before(next) {
+		srv.clock().Sleep(next.Sub(now))
 	}
-	srv.lastLookup = time.Now()
+	srv.lastLookup = srv.now()
 	var target discover.NodeID
 	rand.Read(target[:])
 	t.results = srv.ntab.Lookup(target)
@@ -333,8 +333,8 @@ func (t *discoverTask) String() string {
 	return s
 }
 
-func (t waitExpireTask) Do(*Server) {
-	time.Sleep(t.Duration)
+func (t waitExpireTask) Do(srv *Server) {
+	srv.clock().Sleep(t.Duration)
 }
 func (t waitExpireTask) String() string {
 	return fmt.Sprintf("wait for dial hist expire (%v)", t.Duration)
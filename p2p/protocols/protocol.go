@@ -136,6 +136,35 @@ func (self *CodeMap) Length() uint64 {
 	return uint64(len(self.codes))
 }
 
+// TypeName returns the name of the message type registered for code,
+// empty string if the code is not registered
+func (self *CodeMap) TypeName(code uint64) string {
+	if code >= uint64(len(self.codes)) {
+		return ""
+	}
+	typ := self.codes[code]
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ.Name()
+}
+
+// NewMsg returns the code and a new zero value (pointer) of the message type
+// registered under the given type name (see TypeName)
+func (self *CodeMap) NewMsg(name string) (uint64, interface{}, error) {
+	for code := range self.codes {
+		if self.TypeName(uint64(code)) != name {
+			continue
+		}
+		typ := self.codes[code]
+		if typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		return uint64(code), reflect.New(typ).Interface(), nil
+	}
+	return 0, nil, fmt.Errorf("message type '%v' unknown in protocol %v", name, self.Name)
+}
+
 func (self *CodeMap) Register(msgs ...interface{}) {
 	code := uint(len(self.codes))
 	for _, msg := range msgs {
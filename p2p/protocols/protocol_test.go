@@ -2,12 +2,15 @@ package protocols
 
 import (
 	"fmt"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/adapters"
+	"github.com/ethereum/go-ethereum/p2p/discover"
 	p2ptest "github.com/ethereum/go-ethereum/p2p/testing"
 )
 
@@ -69,6 +72,9 @@ func newProtocol(pp *p2ptest.TestPeerPool, wg *sync.WaitGroup) func(adapters.Nod
 			// demonstrates use of peerPool, killing another peer connection as a response to a message
 			peer.Register(&kill{}, func(msg interface{}) error {
 				id := msg.(*kill).C
+				if id == nil || pp.Get(id) == nil {
+					return fmt.Errorf("cannot kill unknown peer %v", id)
+				}
 				pp.Get(id).Drop()
 				return nil
 			})
@@ -365,3 +371,277 @@ func TestMultiplePeersDropOther(t *testing.T) {
 		fmt.Errorf("p2p: read or write on closed message pipe"),
 	)
 }
+
+func TestScenarios(t *testing.T) {
+	runner := &p2ptest.ScenarioRunner{
+		Types: NewCodeMap("test", 42, 1024, &protoHandshake{}, &hs0{}, &kill{}, &drop{}),
+		Protocol: func() func(adapters.NodeAdapter) adapters.ProtoCall {
+			return newProtocol(p2ptest.NewTestPeerPool(), nil)
+		},
+	}
+	report := runner.Run(t, "testdata/scenarios")
+	if report.Passed != 5 || report.Failed != 0 {
+		t.Fatalf("unexpected report %v", report)
+	}
+}
+
+func TestScenarioBuild(t *testing.T) {
+	ct := NewCodeMap("test", 42, 1024, &protoHandshake{}, &hs0{}, &kill{}, &drop{})
+	ids := p2ptest.RandomNodeIds(2)
+	sc := &p2ptest.Scenario{
+		Exchanges: []*p2ptest.ScenarioExchange{
+			{Triggers: []*p2ptest.ScenarioMsg{{Type: "kill", Fields: []byte(`{"C": "$peer1"}`), Peer: 0}}},
+		},
+		Disconnects: []*p2ptest.ScenarioDisconnect{{Peer: 1, Error: "killed"}},
+	}
+	exchanges, disconnects, err := sc.Build(ct, ids)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	trig := exchanges[0].Triggers[0]
+	if trig.Code != 2 || trig.Peer != ids[0] || trig.Msg.(*kill).C.NodeID != ids[1].NodeID {
+		t.Fatalf("unexpected trigger %+v", trig)
+	}
+	if disconnects[0].Peer != ids[1] || disconnects[0].Error.Error() != "killed" {
+		t.Fatalf("unexpected disconnect %+v", disconnects[0])
+	}
+
+	for _, msg := range []*p2ptest.ScenarioMsg{
+		{Type: "unknown"},
+		{Type: "hs0", Peer: 2},
+		{Type: "hs0", Fields: []byte(`{"C": "many"}`)},
+		{Type: "kill", Fields: []byte(`{"C": "$peer2"}`)},
+	} {
+		sc := &p2ptest.Scenario{Exchanges: []*p2ptest.ScenarioExchange{{Expects: []*p2ptest.ScenarioMsg{msg}}}}
+		if _, _, err := sc.Build(ct, ids); err == nil {
+			t.Fatalf("expected error for %+v", msg)
+		}
+	}
+}
+
+func TestExpectations(t *testing.T) {
+	pp := p2ptest.NewTestPeerPool()
+	s := protocolTester(t, pp, nil)
+	a, b := s.Ids[0], s.Ids[1]
+	s.TestExchanges(testMultiPeerSetup(a, b)...)
+
+	// the module handshake value of a is 43 and of b is 42, a responds to each
+	// hs0 with the sum so far
+	s.TestExchanges(p2ptest.Exchange{
+		Triggers: []p2ptest.Trigger{
+			p2ptest.Trigger{Code: 1, Msg: &hs0{1}, Peer: a},
+			p2ptest.Trigger{Code: 1, Msg: &hs0{1}, Peer: a},
+		},
+		Expects: []p2ptest.Expect{
+			p2ptest.Expect{
+				Peer: a,
+				Unordered: []*p2ptest.ExpectedMsg{
+					{Code: 1, Msg: &hs0{45}},
+					{Code: 1, Msg: &hs0{44}},
+				},
+			},
+			p2ptest.Expect{Peer: b, Absent: true},
+		},
+	})
+
+	s.TestExchanges(p2ptest.Exchange{
+		Triggers: []p2ptest.Trigger{
+			p2ptest.Trigger{Code: 1, Msg: &hs0{2}, Peer: b},
+		},
+		Expects: []p2ptest.Expect{
+			p2ptest.Expect{
+				Code:  1,
+				Msg:   &hs0{},
+				Peer:  b,
+				Match: func(msg interface{}) bool { return msg.(*hs0).C > 42 },
+			},
+		},
+	})
+}
+
+func TestRunExchanges(t *testing.T) {
+	// no *testing.T, errors are returned
+	s := p2ptest.NewProtocolTester(nil, p2ptest.RandomNodeId(), 2, newProtocol(p2ptest.NewTestPeerPool(), nil))
+	s.Timeouts = p2ptest.Timeouts{Expect: 50 * time.Millisecond, Disconnect: 50 * time.Millisecond}
+	a, b := s.Ids[0], s.Ids[1]
+
+	results, err := s.RunExchanges(protoHandshakeExchange(a, &protoHandshake{42, "420"})...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 2 || !results[0].Expects[0].Done || results[0].Expects[0].Duration <= 0 || !results[1].Triggers[0].Done {
+		t.Fatalf("unexpected results %+v %+v", results[0], results[1])
+	}
+
+	// a mismatch shows the differing fields
+	results, err = s.RunExchanges(p2ptest.Exchange{
+		Expects: []p2ptest.Expect{
+			p2ptest.Expect{Peer: b, Unordered: []*p2ptest.ExpectedMsg{{Code: 0, Msg: &protoHandshake{41, "420"}}}},
+		},
+	})
+	if err == nil || !strings.Contains(err.Error(), "Version: expected 41, got 42") {
+		t.Fatalf("expected payload diff, got %v", err)
+	}
+	if len(results) != 1 || results[0].Expects[0].Err == nil {
+		t.Fatalf("unexpected results %+v", results)
+	}
+
+	// the session timeouts apply, b is waiting for a protocol handshake
+	start := time.Now()
+	_, err = s.RunExchanges(p2ptest.Exchange{
+		Expects: []p2ptest.Expect{p2ptest.Expect{Code: 1, Msg: &hs0{42}, Peer: b}},
+	}, p2ptest.Exchange{})
+	if err == nil || !strings.Contains(err.Error(), "timout") {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+	if err := s.CheckDisconnected(&p2ptest.Disconnect{Peer: a}); err == nil {
+		t.Fatalf("expected timeout waiting for disconnect")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("timeouts took %v", elapsed)
+	}
+
+	// unknown peers are errors, not panics
+	c := p2ptest.RandomNodeId()
+	_, err = s.RunExchanges(p2ptest.Exchange{
+		Triggers: []p2ptest.Trigger{p2ptest.Trigger{Code: 0, Msg: &protoHandshake{42, "420"}, Peer: c}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("expected unknown peer error, got %v", err)
+	}
+	_, err = s.RunExchanges(p2ptest.Exchange{
+		Expects: []p2ptest.Expect{p2ptest.Expect{Code: 0, Msg: &protoHandshake{42, "420"}, Peer: c}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("expected unknown peer error, got %v", err)
+	}
+	if err := s.CheckDisconnected(&p2ptest.Disconnect{Peer: c}); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("expected unknown peer error, got %v", err)
+	}
+}
+
+func TestExpectDiff(t *testing.T) {
+	s := p2ptest.NewProtocolTester(nil, p2ptest.RandomNodeId(), 1, newProtocol(p2ptest.NewTestPeerPool(), nil))
+	s.Timeouts = p2ptest.Timeouts{Expect: 50 * time.Millisecond}
+	// plain expectations show the differing fields too
+	_, err := s.RunExchanges(p2ptest.Exchange{
+		Expects: []p2ptest.Expect{p2ptest.Expect{Code: 0, Msg: &protoHandshake{41, "420"}, Peer: s.Ids[0]}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "Version: expected 41, got 42") {
+		t.Fatalf("expected payload diff, got %v", err)
+	}
+}
+
+// gossip is forwarded to all peers except the sender with the hop count
+// incremented
+type gossip struct {
+	Hops uint
+}
+
+func newGossipProtocol(wg *sync.WaitGroup) func(adapters.NodeAdapter) adapters.ProtoCall {
+	ct := NewCodeMap("gossip", 1, 1024, &gossip{})
+	return func(na adapters.NodeAdapter) adapters.ProtoCall {
+		var lock sync.Mutex
+		peers := make(map[discover.NodeID]*Peer)
+		return func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			id := &adapters.NodeId{NodeID: p.ID()}
+			peer := NewPeer(p, rw, ct, na.Messenger(), func() { na.Disconnect(id.Bytes()) })
+			peer.Register(&gossip{}, func(msg interface{}) error {
+				fwd := &gossip{msg.(*gossip).Hops + 1}
+				lock.Lock()
+				var others []*Peer
+				for nid, other := range peers {
+					if nid != id.NodeID {
+						others = append(others, other)
+					}
+				}
+				lock.Unlock()
+				for _, other := range others {
+					if err := other.Send(fwd); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			lock.Lock()
+			peers[id.NodeID] = peer
+			lock.Unlock()
+			wg.Done()
+			return peer.Run()
+		}
+	}
+}
+
+func TestMultiPivot(t *testing.T) {
+	// E0 - P0 - P1 - P2 - E2
+	//            |
+	//            E1
+	wg := &sync.WaitGroup{}
+	// one for each end of a connection a pivot is on
+	wg.Add(7)
+	s, err := p2ptest.NewMultiPivotTester(t, 6, []int{1, 2, 3}, [][2]int{{0, 1}, {1, 2}, {2, 3}, {2, 4}, {3, 5}}, newGossipProtocol(wg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	wg.Wait()
+	if p := s.Pivot(s.Ids[4]); p == nil || p.NodeID != s.Ids[2].NodeID {
+		t.Fatalf("expected pivot of node 4 to be node 2, got %v", p)
+	}
+	s.TestExchanges(p2ptest.Exchange{
+		Triggers: []p2ptest.Trigger{
+			p2ptest.Trigger{Code: 0, Msg: &gossip{0}, Peer: s.Ids[0]},
+		},
+		Expects: []p2ptest.Expect{
+			p2ptest.Expect{Code: 0, Msg: &gossip{2}, Peer: s.Ids[4]},
+			p2ptest.Expect{Code: 0, Msg: &gossip{3}, Peer: s.Ids[5]},
+			p2ptest.Expect{Peer: s.Ids[0], Absent: true},
+		},
+	})
+
+	run := newGossipProtocol(&sync.WaitGroup{})
+	for _, c := range []struct {
+		pivots []int
+		edges  [][2]int
+		err    string
+	}{
+		{[]int{3}, nil, "pivot 3 does not exist"},
+		{[]int{0}, [][2]int{{0, 3}}, "node does not exist"},
+		{[]int{0}, [][2]int{{1, 2}}, "connects two edge nodes"},
+		{[]int{0, 1}, [][2]int{{2, 0}, {1, 2}}, "connected to more than one pivot"},
+	} {
+		_, err := p2ptest.NewMultiPivotTester(t, 3, c.pivots, c.edges, run)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("expected error '%v', got %v", c.err, err)
+		}
+	}
+}
+
+func FuzzCodeMap(f *testing.F) {
+	ct := NewCodeMap("test", 42, 1024, &protoHandshake{}, &hs0{}, &kill{}, &drop{})
+	na := adapters.NewSimNode(p2ptest.RandomNodeId(), nil, &adapters.SimPipe{})
+	fuzzer := &p2ptest.MsgFuzzer{
+		Codes:      ct,
+		Run:        newProtocol(p2ptest.NewTestPeerPool(), nil)(na),
+		MaxMsgSize: ct.MaxMsgSize,
+		// complete the protocol and module handshakes
+		Setup: func(rw p2p.MsgReadWriter) error {
+			for code, hs := range []interface{}{&protoHandshake{42, networkId}, &hs0{42}} {
+				errc := make(chan error, 1)
+				go func() { errc <- p2p.Send(rw, uint64(code), hs) }()
+				if err := p2p.ExpectMsg(rw, uint64(code), nil); err != nil {
+					return err
+				}
+				if err := <-errc; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		IsProtocolError: func(err error) bool {
+			_, ok := err.(*Error)
+			return ok
+		},
+	}
+	fuzzer.Fuzz(f, 1, 4)
+}
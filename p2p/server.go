@@ -25,6 +25,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common/mclock"
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/ethereum/go-ethereum/p2p/discover"
@@ -135,6 +136,11 @@ type Config struct {
 
 	// If NoDial is true, the server will not dial any peers.
 	NoDial bool
+
+	// If Clock is set to a non-nil value, it drives the timers of the server
+	// and its peers (dialling, lookups, pings) instead of the system clock.
+	// Simulations use mclock.Simulated to run in virtual time.
+	Clock mclock.Clock
 }
 
 // Server manages all peer connections.
@@ -173,6 +179,23 @@ type Server struct {
 
 type peerOpFunc func(map[discover.NodeID]*Peer)
 
+// clock returns the clock driving the timers of the server
+func (srv *Server) clock() mclock.Clock {
+	if srv.Clock == nil {
+		return mclock.System{}
+	}
+	return srv.Clock
+}
+
+// now returns the current time of the server's clock, the wall clock time
+// unless a Clock is configured
+func (srv *Server) now() time.Time {
+	if srv.Clock == nil {
+		return time.Now()
+	}
+	return srv.Clock.Now().Time()
+}
+
 type connFlag int
 
 const (
@@ -482,7 +505,7 @@ func (srv *Server) run(dialstate dialer) {
 		queuedTasks = append(queuedTasks[:0], startTasks(queuedTasks)...)
 		// Query dialer for new tasks and start as many as possible now.
 		if len(runningTasks) < maxActiveDialTasks {
-			nt := dialstate.newTasks(len(runningTasks)+len(queuedTasks), peers, time.Now())
+			nt := dialstate.newTasks(len(runningTasks)+len(queuedTasks), peers, srv.now())
 			queuedTasks = append(queuedTasks, startTasks(nt)...)
 		}
 	}
@@ -520,7 +543,7 @@ running:
 			// can update its state and remove it from the active
 			// tasks list.
 			glog.V(logger.Detail).Infoln("<-taskdone:", t)
-			dialstate.taskDone(t, time.Now())
+			dialstate.taskDone(t, srv.now())
 			delTask(t)
 		case c := <-srv.posthandshake:
 			// A connection has passed the encryption handshake so
@@ -542,6 +565,7 @@ running:
 			} else {
 				// The handshakes are done and it passed all checks.
 				p := newPeer(c, srv.Protocols)
+				p.clock = srv.clock()
 				peers[c.id] = p
 				go srv.runPeer(p)
 			}
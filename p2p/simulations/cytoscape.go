@@ -4,6 +4,7 @@ import (
 	// "fmt"
 
 	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p/adapters"
 )
 
 // TODO: to implement cytoscape global behav
@@ -28,13 +29,20 @@ type CyElement struct {
 }
 
 type CyUpdate struct {
-	Add    []*CyElement `json:"add"`
-	Remove []string     `json:"remove"`
+	Add       []*CyElement         `json:"add"`
+	Remove    []string             `json:"remove"`
+	Messages  []*adapters.MsgEvent `json:"messages,omitempty"`  // traffic since the last update
+	Partition *PartitionEvent      `json:"partition,omitempty"` // last partition or heal since the last update
 }
 
-func UpdateCy(conf *CyConfig, j *Journal) (*CyUpdate, error) {
+// UpdateCy returns the changes recorded in the journal since the last update
+// read by r, r is the reader of the cytoscape view and is not shared with other
+// readers of the journal
+func UpdateCy(conf *CyConfig, r *JournalReader) (*CyUpdate, error) {
 	added := []*CyElement{}
 	removed := []string{}
+	var messages []*adapters.MsgEvent
+	var partition *PartitionEvent
 	var el *CyElement
 	update := func(e *event.Event) bool {
 		entry := e.Data
@@ -56,6 +64,12 @@ func UpdateCy(conf *CyConfig, j *Journal) (*CyUpdate, error) {
 			}
 			el = &CyElement{Group: "edges", Data: &CyData{Id: id, Source: source, Target: target}}
 			action = ev.Action
+		} else if ev, ok := entry.(*adapters.MsgEvent); ok {
+			messages = append(messages, ev)
+			return true
+		} else if ev, ok := entry.(*PartitionEvent); ok {
+			partition = ev
+			return true
 		} else {
 			panic("unknown event type")
 		}
@@ -72,10 +86,12 @@ func UpdateCy(conf *CyConfig, j *Journal) (*CyUpdate, error) {
 		}
 		return true
 	}
-	j.Read(update)
+	r.Read(update)
 
 	return &CyUpdate{
-		Add:    added,
-		Remove: removed,
+		Add:       added,
+		Remove:    removed,
+		Messages:  messages,
+		Partition: partition,
 	}, nil
 }
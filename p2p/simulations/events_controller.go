@@ -0,0 +1,156 @@
+package simulations
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/p2p/adapters"
+)
+
+// EventsController streams network events to HTTP clients as server-sent events
+// each client gets its own subscription to the eventer (i.e., its own journal)
+// so that clients do not consume each other's events
+//
+// GET /<networkId>/events?from=<n>
+//
+// if from is given (or the client reconnects with a Last-Event-ID header)
+// events recorded by the network journal from index n onwards are replayed
+// before the live events
+type EventsController struct {
+	*ResourceController
+	eventer *event.TypeMux
+	journal *Journal
+	types   []interface{}
+}
+
+// NewEventsController creates a controller streaming events of the given types
+// posted to the eventer, journal is used to replay past events
+func NewEventsController(eventer *event.TypeMux, journal *Journal, types ...interface{}) *EventsController {
+	return &EventsController{
+		ResourceController: NewResourceContoller(&ResourceHandlers{}),
+		eventer:            eventer,
+		journal:            journal,
+		types:              types,
+	}
+}
+
+// ServeStream subscribes to the eventer and writes the events to the
+// response until the client goes away
+func (self *EventsController) ServeStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	from := -1
+	if s := r.Header.Get("Last-Event-ID"); len(s) > 0 {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid Last-Event-ID %v", s), http.StatusBadRequest)
+			return
+		}
+		from = n + 1
+	}
+	if s := r.URL.Query().Get("from"); len(s) > 0 {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("invalid offset %v", s), http.StatusBadRequest)
+			return
+		}
+		from = n
+	}
+
+	// without an offset the stream starts with the events appended from now
+	// on, they are taken from the history too so that the ids of events
+	// posted while subscribing are those of the network journal
+	if from < 0 {
+		from = self.journal.Counter()
+	}
+	// subscribe before retrieving history so that no event is missed
+	j := NewJournal()
+	j.Subscribe(self.eventer, self.types...)
+	defer j.Close()
+
+	history, id := self.journal.History(from)
+	// events both replayed and received on the subscription are only sent once
+	replayed := make(map[*event.Event]bool)
+	for _, ev := range history {
+		replayed[ev] = true
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	send := func(ev *event.Event) bool {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			glog.V(6).Infof("cannot encode event %v: %v", ev, err)
+			return true
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, eventType(ev.Data), data)
+		if err != nil {
+			return false
+		}
+		id++
+		return true
+	}
+	for _, ev := range history {
+		if !send(ev) {
+			return
+		}
+	}
+	flusher.Flush()
+
+	// closing the journal of the client releases WaitEntries once the client
+	// goes away
+	quitc := make(chan bool)
+	defer close(quitc)
+	go func() {
+		select {
+		case <-r.Context().Done():
+			j.Close()
+		case <-quitc:
+		}
+	}()
+	for {
+		j.WaitEntries(1)
+		if r.Context().Err() != nil {
+			glog.V(6).Infof("event stream client gone")
+			return
+		}
+		ok := true
+		j.Read(func(ev *event.Event) bool {
+			if replayed[ev] {
+				delete(replayed, ev)
+				return true
+			}
+			ok = send(ev)
+			return ok
+		})
+		if !ok {
+			return
+		}
+		// the journal of the client only needs the events not yet sent
+		j.Discard(j.Cursor())
+		flusher.Flush()
+	}
+}
+
+// eventType returns the type name of the event data used to label streamed events
+func eventType(data interface{}) string {
+	switch ev := data.(type) {
+	case *NodeEvent:
+		return ev.Type
+	case *ConnEvent:
+		return ev.Type
+	case *adapters.MsgEvent:
+		return ev.Type
+	case *PartitionEvent:
+		return ev.Type
+	}
+	return fmt.Sprintf("%T", data)
+}
@@ -0,0 +1,460 @@
+package simulations
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/p2p/adapters"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+// Graph is a view of the topology of a network for export to graph analysis
+// and drawing tools (see GraphFormats)
+// edges point from the node that dialled the connection to the callee
+// traffic counters are taken from the msg events recorded by the journal
+type Graph struct {
+	Id    string
+	Nodes []*GraphNode
+	Edges []*GraphEdge
+	nodes map[discover.NodeID]*GraphNode
+	edges map[string]*GraphEdge
+}
+
+// GraphNode is a node of the graph, Group is the index of the partition group
+// of the node, -1 if the node is not restricted by a partition
+type GraphNode struct {
+	Id            *adapters.NodeId
+	Up            bool
+	Group         int
+	MsgsSent      int
+	MsgsReceived  int
+	BytesSent     int
+	BytesReceived int
+}
+
+// GraphEdge is a connection of the graph, traffic is counted in both directions
+type GraphEdge struct {
+	Source *adapters.NodeId
+	Target *adapters.NodeId
+	Up     bool
+	Msgs   int
+	Bytes  int
+}
+
+func newGraph(id string) *Graph {
+	return &Graph{
+		Id:    id,
+		nodes: make(map[discover.NodeID]*GraphNode),
+		edges: make(map[string]*GraphEdge),
+	}
+}
+
+func (self *Graph) node(id *adapters.NodeId) *GraphNode {
+	node, ok := self.nodes[id.NodeID]
+	if !ok {
+		node = &GraphNode{Id: id, Group: -1}
+		self.nodes[id.NodeID] = node
+		self.Nodes = append(self.Nodes, node)
+	}
+	return node
+}
+
+func (self *Graph) edge(one, other *adapters.NodeId, reverse bool) *GraphEdge {
+	label := ConnLabel(one, other)
+	edge, ok := self.edges[label]
+	if !ok {
+		edge = &GraphEdge{}
+		self.edges[label] = edge
+		self.Edges = append(self.Edges, edge)
+	}
+	if reverse {
+		one, other = other, one
+	}
+	edge.Source = one
+	edge.Target = other
+	return edge
+}
+
+// count adds the traffic of msg events to the counters, messages between
+// nodes not in the graph are ignored
+func (self *Graph) count(events []*event.Event) {
+	for _, ev := range events {
+		msg, ok := ev.Data.(*adapters.MsgEvent)
+		if !ok {
+			continue
+		}
+		from, ok := self.nodes[msg.From.NodeID]
+		if !ok {
+			continue
+		}
+		to, ok := self.nodes[msg.To.NodeID]
+		if !ok {
+			continue
+		}
+		from.MsgsSent++
+		from.BytesSent += int(msg.Size)
+		to.MsgsReceived++
+		to.BytesReceived += int(msg.Size)
+		if edge, ok := self.edges[ConnLabel(msg.From, msg.To)]; ok {
+			edge.Msgs++
+			edge.Bytes += int(msg.Size)
+		}
+	}
+}
+
+// NewNetworkGraph returns the graph of the current state of the network
+// the traffic counters are taken from the journal if given
+func NewNetworkGraph(id string, net *Network, j *Journal) *Graph {
+	self := newGraph(id)
+	net.lock.Lock()
+	partition := net.partition
+	net.lock.Unlock()
+	for _, n := range net.GetNodes() {
+		node := self.node(n.Id)
+		node.Up = n.Up
+		if group, found := partition[n.Id.NodeID]; found {
+			node.Group = group
+		}
+	}
+	for _, c := range net.GetConns() {
+		self.edge(c.One, c.Other, c.Reverse).Up = c.Up
+	}
+	if j != nil {
+		events, _ := j.History(0)
+		self.count(events)
+	}
+	return self
+}
+
+// NewJournalGraph returns the graph of the network recorded by the journal
+// after its first n events (all events if n is negative)
+// nodes and connections stay in the graph once they are mentioned, down
+// ones are marked as such
+func NewJournalGraph(id string, j *Journal, n int) *Graph {
+	self := newGraph(id)
+	events, _ := j.History(0)
+	if n >= 0 && n < len(events) {
+		events = events[:n]
+	}
+	for _, ev := range events {
+		switch e := ev.Data.(type) {
+		case *NodeEvent:
+			self.node(e.node.Id).Up = e.Action == "up"
+		case *ConnEvent:
+			self.node(e.conn.One)
+			self.node(e.conn.Other)
+			self.edge(e.conn.One, e.conn.Other, e.conn.Reverse).Up = e.Action == "up"
+		case *PartitionEvent:
+			for _, node := range self.Nodes {
+				node.Group = -1
+			}
+			if e.Action != "partition" {
+				continue
+			}
+			for i, group := range e.Groups {
+				for _, id := range group {
+					self.node(id).Group = i
+				}
+			}
+		}
+	}
+	self.count(events)
+	return self
+}
+
+// GraphFormat encodes graphs in a format known by ContentType
+type GraphFormat struct {
+	ContentType string
+	Encode      func(w io.Writer, g *Graph) error
+}
+
+// GraphFormats are the formats graphs can be exported in
+// graphml for Gephi, yEd etc, dot for Graphviz and json in the node-link
+// format of NetworkX (networkx.readwrite.json_graph.node_link_graph)
+var GraphFormats = map[string]*GraphFormat{
+	"graphml": {"application/graphml+xml", WriteGraphML},
+	"dot":     {"text/vnd.graphviz", WriteDOT},
+	"json":    {"application/json", WriteNodeLink},
+}
+
+// Encode writes the graph in the named format
+func (self *Graph) Encode(w io.Writer, format string) error {
+	f, ok := GraphFormats[format]
+	if !ok {
+		return fmt.Errorf("unknown graph format '%v'", format)
+	}
+	return f.Encode(w, self)
+}
+
+type graphMLKey struct {
+	Id   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLElement struct {
+	Id     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr,omitempty"`
+	Target string        `xml:"target,attr,omitempty"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLGraph struct {
+	Id          string           `xml:"id,attr,omitempty"`
+	EdgeDefault string           `xml:"edgedefault,attr"`
+	Nodes       []graphMLElement `xml:"node"`
+	Edges       []graphMLElement `xml:"edge"`
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"http://graphml.graphdrawing.org/xmlns graphml"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+var graphMLKeys = []graphMLKey{
+	{"label", "node", "label", "string"},
+	{"up", "node", "up", "boolean"},
+	{"group", "node", "group", "int"},
+	{"msgsSent", "node", "msgsSent", "int"},
+	{"msgsReceived", "node", "msgsReceived", "int"},
+	{"bytesSent", "node", "bytesSent", "int"},
+	{"bytesReceived", "node", "bytesReceived", "int"},
+	{"edgeUp", "edge", "up", "boolean"},
+	{"msgs", "edge", "msgs", "int"},
+	{"bytes", "edge", "bytes", "int"},
+}
+
+// WriteGraphML writes the graph as GraphML
+func WriteGraphML(w io.Writer, g *Graph) error {
+	doc := &graphML{
+		Keys:  graphMLKeys,
+		Graph: graphMLGraph{Id: g.Id, EdgeDefault: "directed"},
+	}
+	for _, n := range g.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLElement{
+			Id: n.Id.String(),
+			Data: []graphMLData{
+				{"label", n.Id.Label()},
+				{"up", strconv.FormatBool(n.Up)},
+				{"group", strconv.Itoa(n.Group)},
+				{"msgsSent", strconv.Itoa(n.MsgsSent)},
+				{"msgsReceived", strconv.Itoa(n.MsgsReceived)},
+				{"bytesSent", strconv.Itoa(n.BytesSent)},
+				{"bytesReceived", strconv.Itoa(n.BytesReceived)},
+			},
+		})
+	}
+	for _, e := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLElement{
+			Id:     ConnLabel(e.Source, e.Target),
+			Source: e.Source.String(),
+			Target: e.Target.String(),
+			Data: []graphMLData{
+				{"edgeUp", strconv.FormatBool(e.Up)},
+				{"msgs", strconv.Itoa(e.Msgs)},
+				{"bytes", strconv.Itoa(e.Bytes)},
+			},
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteDOT writes the graph as a Graphviz digraph, down nodes and connections
+// are drawn dashed
+func WriteDOT(w io.Writer, g *Graph) error {
+	style := func(up bool) string {
+		if up {
+			return "solid"
+		}
+		return "dashed"
+	}
+	lines := []string{fmt.Sprintf("digraph %s {", strconv.Quote(g.Id))}
+	for _, n := range g.Nodes {
+		lines = append(lines, fmt.Sprintf("  %s [label=%s, style=%s, up=%v, group=%d, msgsSent=%d, msgsReceived=%d, bytesSent=%d, bytesReceived=%d];",
+			strconv.Quote(n.Id.String()), strconv.Quote(n.Id.Label()), style(n.Up), n.Up, n.Group, n.MsgsSent, n.MsgsReceived, n.BytesSent, n.BytesReceived))
+	}
+	for _, e := range g.Edges {
+		lines = append(lines, fmt.Sprintf("  %s -> %s [style=%s, up=%v, msgs=%d, bytes=%d];",
+			strconv.Quote(e.Source.String()), strconv.Quote(e.Target.String()), style(e.Up), e.Up, e.Msgs, e.Bytes))
+	}
+	lines = append(lines, "}\n")
+	_, err := io.WriteString(w, strings.Join(lines, "\n"))
+	return err
+}
+
+type nodeLinkNode struct {
+	Id            string `json:"id"`
+	Label         string `json:"label"`
+	Up            bool   `json:"up"`
+	Group         int    `json:"group"`
+	MsgsSent      int    `json:"msgsSent"`
+	MsgsReceived  int    `json:"msgsReceived"`
+	BytesSent     int    `json:"bytesSent"`
+	BytesReceived int    `json:"bytesReceived"`
+}
+
+type nodeLinkLink struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Up     bool   `json:"up"`
+	Msgs   int    `json:"msgs"`
+	Bytes  int    `json:"bytes"`
+}
+
+type nodeLinkGraph struct {
+	Directed   bool              `json:"directed"`
+	Multigraph bool              `json:"multigraph"`
+	Graph      map[string]string `json:"graph"`
+	Nodes      []*nodeLinkNode   `json:"nodes"`
+	Links      []*nodeLinkLink   `json:"links"`
+}
+
+// WriteNodeLink writes the graph as node-link JSON
+func WriteNodeLink(w io.Writer, g *Graph) error {
+	doc := &nodeLinkGraph{
+		Directed: true,
+		Graph:    map[string]string{"id": g.Id},
+		Nodes:    []*nodeLinkNode{},
+		Links:    []*nodeLinkLink{},
+	}
+	for _, n := range g.Nodes {
+		doc.Nodes = append(doc.Nodes, &nodeLinkNode{
+			Id:            n.Id.String(),
+			Label:         n.Id.Label(),
+			Up:            n.Up,
+			Group:         n.Group,
+			MsgsSent:      n.MsgsSent,
+			MsgsReceived:  n.MsgsReceived,
+			BytesSent:     n.BytesSent,
+			BytesReceived: n.BytesReceived,
+		})
+	}
+	for _, e := range g.Edges {
+		doc.Links = append(doc.Links, &nodeLinkLink{
+			Source: e.Source.String(),
+			Target: e.Target.String(),
+			Up:     e.Up,
+			Msgs:   e.Msgs,
+			Bytes:  e.Bytes,
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// graphFormat returns the format requested by the format parameter or else
+// the Accept header, json by default
+func graphFormat(r *http.Request) (string, error) {
+	if format := r.URL.Query().Get("format"); len(format) > 0 {
+		if _, ok := GraphFormats[format]; !ok {
+			return "", fmt.Errorf("unknown graph format '%v'", format)
+		}
+		return format, nil
+	}
+	accept := r.Header.Get("Accept")
+	if len(accept) == 0 {
+		return "json", nil
+	}
+	for _, t := range strings.Split(accept, ",") {
+		t = strings.TrimSpace(strings.Split(t, ";")[0])
+		switch t {
+		case "*/*", "application/*":
+			return "json", nil
+		case "application/xml", "text/xml":
+			return "graphml", nil
+		}
+		for name, f := range GraphFormats {
+			if t == f.ContentType {
+				return name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no graph format for %v", accept)
+}
+
+// GraphController exports the network as a graph (see Graph)
+//
+// GET /<networkId>/graph?format=<format>&journal=<journalId>&events=<n>
+//
+// the format is one of GraphFormats, if not given it is negotiated by the
+// Accept header. without parameters the graph is the current state of the
+// network, with events the state recorded by the network journal after
+// n events, with journal the state recorded by an uploaded journal
+type GraphController struct {
+	*ResourceController
+	id       string
+	net      *Network
+	journal  *Journal
+	journals *JournalController
+}
+
+// NewGraphController creates a graph controller for the network with the given
+// id, journal is the network journal and journals the uploaded ones
+func NewGraphController(id string, net *Network, journal *Journal, journals *JournalController) *GraphController {
+	return &GraphController{
+		ResourceController: NewResourceContoller(&ResourceHandlers{}),
+		id:                 id,
+		net:                net,
+		journal:            journal,
+		journals:           journals,
+	}
+}
+
+// ServeStream writes the graph in the requested format
+func (self *GraphController) ServeStream(w http.ResponseWriter, r *http.Request) {
+	format, err := graphFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return
+	}
+	query := r.URL.Query()
+	n := -1
+	if s := query.Get("events"); len(s) > 0 {
+		n, err = strconv.Atoi(s)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("invalid number of events %v", s), http.StatusBadRequest)
+			return
+		}
+	}
+	var g *Graph
+	if id := query.Get("journal"); len(id) > 0 {
+		j := self.journals.Journal(id)
+		if j == nil {
+			http.Error(w, fmt.Sprintf("journal '%v' not found", id), http.StatusNotFound)
+			return
+		}
+		g = NewJournalGraph(id, j, n)
+	} else if n >= 0 {
+		g = NewJournalGraph(self.id, self.journal, n)
+	} else {
+		g = NewNetworkGraph(self.id, self.net, self.journal)
+	}
+	w.Header().Set("Content-Type", GraphFormats[format].ContentType)
+	if err := g.Encode(w, format); err != nil {
+		glog.V(6).Infof("cannot write %v graph: %v", format, err)
+	}
+}
@@ -0,0 +1,172 @@
+package simulations
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p/adapters"
+)
+
+func testGraphJournal(ids []*adapters.NodeId) *Journal {
+	now := time.Now()
+	node := func(action string, id *adapters.NodeId) *event.Event {
+		return &event.Event{Time: now, Data: &NodeEvent{Type: "node", Action: action, node: &Node{Id: id}}}
+	}
+	conn := func(action string, one, other *adapters.NodeId, reverse bool) *event.Event {
+		return &event.Event{Time: now, Data: &ConnEvent{Type: "conn", Action: action, conn: &Conn{One: one, Other: other, Reverse: reverse}}}
+	}
+	msg := func(from, to *adapters.NodeId, size uint32) *event.Event {
+		return &event.Event{Time: now, Data: &adapters.MsgEvent{Type: "msg", From: from, To: to, Size: size}}
+	}
+	j := NewJournal()
+	j.append(
+		node("up", ids[0]),
+		node("up", ids[1]),
+		node("up", ids[2]),
+		conn("up", ids[0], ids[1], false),
+		conn("up", ids[1], ids[2], true),
+		msg(ids[0], ids[1], 10),
+		msg(ids[1], ids[0], 20),
+		&event.Event{Time: now, Data: &PartitionEvent{Type: "partition", Action: "partition", Groups: [][]*adapters.NodeId{ids[:2], ids[2:]}}},
+		conn("down", ids[1], ids[2], true),
+		node("down", ids[2]),
+	)
+	return j
+}
+
+func TestJournalGraph(t *testing.T) {
+	ids := RandomNodeIds(3)
+	j := testGraphJournal(ids)
+
+	g := NewJournalGraph("test", j, -1)
+	if len(g.Nodes) != 3 || len(g.Edges) != 2 {
+		t.Fatalf("expected 3 nodes and 2 edges, got %v and %v", len(g.Nodes), len(g.Edges))
+	}
+	if !g.Nodes[0].Up || g.Nodes[2].Up || g.Nodes[2].Group != 1 {
+		t.Fatalf("unexpected nodes %+v %+v", g.Nodes[0], g.Nodes[2])
+	}
+	if g.Nodes[0].MsgsSent != 1 || g.Nodes[0].BytesSent != 10 || g.Nodes[0].BytesReceived != 20 {
+		t.Fatalf("unexpected traffic of node 0: %+v", g.Nodes[0])
+	}
+	if e := g.Edges[0]; !e.Up || e.Msgs != 2 || e.Bytes != 30 {
+		t.Fatalf("unexpected edge %+v", e)
+	}
+	// the second connection was dialled by the other node
+	if e := g.Edges[1]; e.Up || e.Source.String() != ids[2].String() || e.Target.String() != ids[1].String() {
+		t.Fatalf("unexpected edge %+v", e)
+	}
+
+	// the state after the first five events
+	g = NewJournalGraph("test", j, 5)
+	if !g.Nodes[2].Up || !g.Edges[1].Up || g.Nodes[2].Group != -1 || g.Edges[0].Msgs != 0 {
+		t.Fatalf("unexpected graph after 5 events: %+v %+v", g.Nodes[2], g.Edges)
+	}
+}
+
+func TestGraphFormats(t *testing.T) {
+	ids := RandomNodeIds(3)
+	g := NewJournalGraph("test", testGraphJournal(ids), -1)
+
+	buf := &bytes.Buffer{}
+	if err := g.Encode(buf, "graphml"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	doc := &graphML{}
+	if err := xml.Unmarshal(buf.Bytes(), doc); err != nil {
+		t.Fatalf("invalid GraphML: %v", err)
+	}
+	if len(doc.Graph.Nodes) != 3 || len(doc.Graph.Edges) != 2 || doc.Graph.Edges[0].Source != ids[0].String() {
+		t.Fatalf("unexpected GraphML %+v", doc.Graph)
+	}
+
+	buf.Reset()
+	if err := g.Encode(buf, "dot"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dot := buf.String()
+	if !strings.HasPrefix(dot, `digraph "test" {`) || strings.Count(dot, "->") != 2 || strings.Count(dot, "style=dashed") != 2 {
+		t.Fatalf("unexpected DOT %v", dot)
+	}
+
+	buf.Reset()
+	if err := g.Encode(buf, "json"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	nl := &nodeLinkGraph{}
+	if err := json.Unmarshal(buf.Bytes(), nl); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if !nl.Directed || len(nl.Nodes) != 3 || len(nl.Links) != 2 || nl.Links[0].Bytes != 30 {
+		t.Fatalf("unexpected node-link graph %+v", nl)
+	}
+
+	if err := g.Encode(buf, "gexf"); err == nil {
+		t.Fatalf("expected error for unknown format")
+	}
+}
+
+func TestGraphController(t *testing.T) {
+	net := NewNetwork(nil, &event.TypeMux{})
+	net.SetNaf(func(conf *NodeConfig) adapters.NodeAdapter {
+		return adapters.NewSimNode(conf.Id, net, &adapters.SimPipe{})
+	})
+	journal := NewJournal()
+	conf := &NetworkConfig{Id: "graph"}
+	controller.SetResource(conf.Id, NewNetworkController(conf, net, journal))
+	ids := testIDs()
+	for _, id := range ids {
+		if err := net.NewNode(&NodeConfig{Id: id}); err != nil {
+			t.Fatalf("unexpected error creating node: %v", err)
+		}
+		if err := net.Start(id); err != nil {
+			t.Fatalf("unexpected error starting node: %v", err)
+		}
+	}
+	journal.WaitEntries(len(ids))
+
+	for _, test := range []struct {
+		path, accept, contentType string
+		status                    int
+	}{
+		{"graph/graph", "", "application/json", http.StatusOK},
+		{"graph/graph?format=dot", "", "text/vnd.graphviz", http.StatusOK},
+		{"graph/graph", "text/xml;q=0.9, application/graphml+xml", "application/graphml+xml", http.StatusOK},
+		{"graph/graph?events=1&format=json", "", "application/json", http.StatusOK},
+		{"graph/graph?format=gexf", "", "", http.StatusNotAcceptable},
+		{"graph/graph", "image/png", "", http.StatusNotAcceptable},
+		{"graph/graph?journal=unknown", "", "", http.StatusNotFound},
+	} {
+		req, err := http.NewRequest("GET", url(port, test.path), nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(test.accept) > 0 {
+			req.Header.Set("Accept", test.accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error on request: %v", err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Fatalf("%v (%v): expected status %v, got %v: %s", test.path, test.accept, test.status, resp.StatusCode, body)
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != test.contentType {
+			t.Fatalf("%v (%v): expected content type %v, got %v", test.path, test.accept, test.contentType, ct)
+		}
+		if !bytes.Contains(body, []byte(ids[0].String())) {
+			t.Fatalf("%v: expected graph to contain node %v, got %s", test.path, ids[0], body)
+		}
+	}
+}
@@ -4,26 +4,38 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"math/rand"
+	"io"
 	"reflect"
 	"sync"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common/mclock"
 	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/ethereum/go-ethereum/p2p/adapters"
+	"github.com/ethereum/go-ethereum/p2p/discover"
 )
 
 // Journal is an instance of a guaranteed no-loss subscription to network related events
 // (using event.TypeMux). Network components POST events to the TypeMux, which then is
 // read by the journal. Each journal belongs to a subscription.
+//
+// the journal keeps the full history of events unless a retention bound is
+// set (see SetRetention), events are indexed by the number of events appended
+// before them. Reading does not discard events, each JournalReader has its own
+// cursor, the journal itself has a default cursor used by Read and WaitEntries
 type Journal struct {
-	Id      string
-	lock    sync.Mutex
-	counter int
-	cursor  int
-	quitc   chan bool
-	Events  []*event.Event
+	Id        string
+	lock      sync.Mutex
+	cond      *sync.Cond
+	counter   int
+	cursor    int // index of the next event for the default reader
+	offset    int // number of events discarded from the log
+	retention int
+	closed    bool
+	clock     mclock.Clock
+	quitc     chan bool
+	Events    []*event.Event
 }
 
 // NewJournal constructor
@@ -36,6 +48,13 @@ func NewJournal() *Journal {
 	return &Journal{quitc: make(chan bool)}
 }
 
+// SetClock sets the clock used for timed reads of the journal (see TimedRead)
+func (self *Journal) SetClock(clock mclock.Clock) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.clock = clock
+}
+
 // Subscribe takes an event.TypeMux and subscibes to types
 // and launches a gorourine that appends any new event to the event log
 // used for journalling history of a network
@@ -90,49 +109,215 @@ func Replay(acc float64, j *Journal, eventer *event.TypeMux) {
 // Snapshot creates a snapshot out of the journal
 // this is simply done by reading the event log backwards and mark the last action
 // on a node/connection ignoring all earlier mentions
-// TODO: implmented
+// the resulting journal contains only node up events followed by conn up events
+// (connections are only kept if both their nodes are up) and the partition of
+// the network if one is in effect, all timestamped with
+// the time of the last event so that the snapshot can be replayed instantly
+// the snapshot does not advance the cursor of the journal
 func Snapshot(conf *SnapshotConfig, j *Journal) (*Journal, error) {
-	return nil, fmt.Errorf("snapshot not implemented")
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	nodesSeen := make(map[discover.NodeID]bool)
+	nodesUp := make(map[discover.NodeID]bool)
+	connsSeen := make(map[string]bool)
+	var nodes []*Node
+	var conns []*Conn
+	var partition *PartitionEvent
+	var partitionSeen bool
+	for i := len(j.Events) - 1; i >= 0; i-- {
+		switch ev := j.Events[i].Data.(type) {
+		case *NodeEvent:
+			id := ev.node.Id
+			if nodesSeen[id.NodeID] {
+				continue
+			}
+			nodesSeen[id.NodeID] = true
+			if ev.Action != "up" {
+				continue
+			}
+			nodesUp[id.NodeID] = true
+			nodes = append(nodes, &Node{Id: id, Up: true, config: ev.node.config})
+		case *ConnEvent:
+			label := ConnLabel(ev.conn.One, ev.conn.Other)
+			if connsSeen[label] {
+				continue
+			}
+			connsSeen[label] = true
+			if ev.Action != "up" {
+				continue
+			}
+			conns = append(conns, &Conn{
+				One:     ev.conn.One,
+				Other:   ev.conn.Other,
+				Up:      true,
+				Reverse: ev.conn.Reverse,
+			})
+		case *PartitionEvent:
+			if partitionSeen {
+				continue
+			}
+			partitionSeen = true
+			if ev.Action == "partition" {
+				partition = ev
+			}
+		}
+	}
+
+	t := time.Now()
+	if len(j.Events) > 0 {
+		t = j.Events[len(j.Events)-1].Time
+	}
+	snapshot := NewJournal()
+	snapshot.Id = conf.Id
+	// nodes and conns were collected backwards
+	for i := len(nodes) - 1; i >= 0; i-- {
+		snapshot.Events = append(snapshot.Events, &event.Event{Time: t, Data: nodes[i].event(true)})
+	}
+	for i := len(conns) - 1; i >= 0; i-- {
+		conn := conns[i]
+		if !nodesUp[conn.One.NodeID] || !nodesUp[conn.Other.NodeID] {
+			continue
+		}
+		snapshot.Events = append(snapshot.Events, &event.Event{Time: t, Data: conn.event(true, conn.Reverse)})
+	}
+	if partition != nil {
+		snapshot.Events = append(snapshot.Events, &event.Event{Time: t, Data: partition})
+	}
+	snapshot.counter = len(snapshot.Events)
+	return snapshot, nil
 }
 
+// Close terminates the subscription of the journal and releases waiting readers
 func (self *Journal) Close() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.closed {
+		return
+	}
+	self.closed = true
 	close(self.quitc)
+	self.condition().Broadcast()
+}
+
+// condition returns the condition variable signalled on new events, the lock
+// must be held
+func (self *Journal) condition() *sync.Cond {
+	if self.cond == nil {
+		self.cond = sync.NewCond(&self.lock)
+	}
+	return self.cond
 }
 
 func (self *Journal) append(evs ...*event.Event) {
 	self.lock.Lock()
 	defer self.lock.Unlock()
 	self.Events = append(self.Events, evs...)
-	self.counter++
+	self.counter += len(evs)
+	if self.retention > 0 && len(self.Events) > self.retention {
+		self.discard(self.end() - self.retention)
+	}
+	self.condition().Broadcast()
+}
+
+// SetRetention bounds the number of events kept, older events are discarded
+// (readers that have not read them skip them), 0 means no bound
+func (self *Journal) SetRetention(n int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.retention = n
+	if n > 0 && len(self.Events) > n {
+		self.discard(self.end() - n)
+	}
 }
 
+// Discard drops the events before index n from the history, used by the owner
+// of a journal once the events are consumed
+func (self *Journal) Discard(n int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.discard(n)
+}
+
+func (self *Journal) discard(n int) {
+	if n <= self.offset {
+		return
+	}
+	if n > self.end() {
+		n = self.end()
+	}
+	self.Events = self.Events[n-self.offset:]
+	self.offset = n
+}
+
+// NewEntries returns the number of events not yet read by the default reader
 func (self *Journal) NewEntries() int {
 	self.lock.Lock()
 	defer self.lock.Unlock()
-	return self.counter - self.cursor
+	return self.newEntries(self.cursor)
+}
+
+func (self *Journal) newEntries(cursor int) int {
+	if cursor < self.offset {
+		cursor = self.offset
+	}
+	return self.end() - cursor
+}
+
+// end returns the index following the last event
+func (self *Journal) end() int {
+	return self.offset + len(self.Events)
+}
+
+// History returns the events of the log from index n onwards, where n counts
+// all the events ever appended to the journal. Events discarded (see
+// SetRetention) are not available, the index of the first event returned
+// is given as the second return value
+func (self *Journal) History(n int) ([]*event.Event, int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if n < self.offset {
+		n = self.offset
+	}
+	if n > self.offset+len(self.Events) {
+		n = self.offset + len(self.Events)
+	}
+	events := make([]*event.Event, self.offset+len(self.Events)-n)
+	copy(events, self.Events[n-self.offset:])
+	return events, n
 }
 
+// WaitEntries blocks until there are n events not yet read by the default
+// reader or the journal is closed
 func (self *Journal) WaitEntries(n int) {
-	for self.NewEntries() < n {
-		time.Sleep(10 * time.Millisecond)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.wait(&self.cursor, n)
+}
+
+func (self *Journal) wait(cursor *int, n int) {
+	for self.newEntries(*cursor) < n && !self.closed {
+		self.condition().Wait()
 	}
 }
 
+// Read calls f on the events not yet read by the default reader until f
+// returns false, it returns the number of events read
 func (self *Journal) Read(f func(*event.Event) bool) (read int) {
 	self.lock.Lock()
 	defer self.lock.Unlock()
+	return self.read(&self.cursor, f)
+}
+
+func (self *Journal) read(cursor *int, f func(*event.Event) bool) (read int) {
+	if *cursor < self.offset {
+		*cursor = self.offset
+	}
 	ok := true
-	for self.cursor < len(self.Events) && ok {
+	for *cursor < self.end() && ok {
 		read++
-		ok = f(self.Events[self.cursor])
-		self.cursor++
-		select {
-		case <-self.quitc:
-			break
-		default:
-		}
+		ok = f(self.Events[*cursor-self.offset])
+		*cursor++
 	}
-	self.reset(self.cursor)
 	return read
 }
 
@@ -141,9 +326,10 @@ func (self *Journal) Read(f func(*event.Event) bool) (read int) {
 // NOTE: the events' timestamps are supposed to be strictly ordered otherwise
 // the call panics.
 // acc is an acceleration factor
+// intervals are measured on the clock of the journal (system clock by default)
 func (self *Journal) TimedRead(acc float64, f func(interface{}) bool) (read int) {
 	var lastEvent time.Time
-	timer := time.NewTimer(0)
+	var timerc <-chan time.Time
 	var data interface{}
 	h := func(ev *event.Event) bool {
 		// wait for the interval time passes event time
@@ -152,7 +338,11 @@ func (self *Journal) TimedRead(acc float64, f func(interface{}) bool) (read int)
 		}
 		interval := ev.Time.Sub(lastEvent)
 		glog.V(6).Infof("reset timer to interval %v", interval)
-		timer.Reset(time.Duration(acc) * interval)
+		clock := self.clock
+		if clock == nil {
+			clock = mclock.System{}
+		}
+		timerc = clock.After(time.Duration(acc) * interval)
 		lastEvent = ev.Time
 		data = ev.Data
 		return false
@@ -166,7 +356,7 @@ func (self *Journal) TimedRead(acc float64, f func(interface{}) bool) (read int)
 			select {
 			case <-self.quitc:
 				break
-			case <-timer.C:
+			case <-timerc:
 			}
 		}
 		read += n
@@ -178,23 +368,11 @@ func (self *Journal) TimedRead(acc float64, f func(interface{}) bool) (read int)
 	return read
 }
 
+// Reset moves the default cursor to index n
 func (self *Journal) Reset(n int) {
 	self.lock.Lock()
 	defer self.lock.Unlock()
-	self.reset(n)
-}
-
-func (self *Journal) reset(n int) {
-	length := len(self.Events)
-	if length == 0 {
-		return
-	}
-	if n >= length-1 {
-		n = length - 1
-	}
-	glog.V(6).Infof("cursor reset from %v to %v/%v (%v)", self.cursor, n, len(self.Events), self.counter)
-	self.Events = self.Events[self.cursor:]
-	self.cursor = 0
+	self.cursor = n
 }
 
 func (self *Journal) Counter() int {
@@ -203,123 +381,169 @@ func (self *Journal) Counter() int {
 	return self.counter
 }
 
-// type History()
-
+// Cursor returns the index of the next event for the default reader
 func (self *Journal) Cursor() int {
 	self.lock.Lock()
 	defer self.lock.Unlock()
 	return self.cursor
 }
 
-type SnapshotConfig struct {
-	Id string
+// JournalReader reads the events of a journal independently of other readers
+type JournalReader struct {
+	journal *Journal
+	cursor  int
 }
 
-type JournalPlayConfig struct {
-	Id      string
-	SpeedUp float64
-	Journal *Journal
-	Events  []string
+// NewReader creates a reader of the journal starting at index n
+// use Counter() to read only events appended from now on
+func (self *Journal) NewReader(n int) *JournalReader {
+	return &JournalReader{journal: self, cursor: n}
 }
 
-func NewJournalPlayersController(eventer *event.TypeMux) Controller {
-	self := NewResourceContoller(
-		&ResourceHandlers{
-			// POST /o/players/
-			Create: &ResourceHandler{
-				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
-					conf := msg.(*JournalPlayConfig)
-					go Replay(conf.SpeedUp, conf.Journal, eventer)
-					c := NewJournalPlayerController(conf)
-					parent.SetResource(conf.Id, c)
-					return empty, nil
-				},
-				Type: reflect.TypeOf(&JournalPlayConfig{}),
-			},
-		})
-	return self
+// Read calls f on the events not yet read by the reader until f returns false
+// it returns the number of events read
+func (self *JournalReader) Read(f func(*event.Event) bool) int {
+	self.journal.lock.Lock()
+	defer self.journal.lock.Unlock()
+	return self.journal.read(&self.cursor, f)
+}
+
+// NewEntries returns the number of events not yet read by the reader
+func (self *JournalReader) NewEntries() int {
+	self.journal.lock.Lock()
+	defer self.journal.lock.Unlock()
+	return self.journal.newEntries(self.cursor)
+}
+
+// WaitEntries blocks until there are n events not yet read by the reader
+// or the journal is closed
+func (self *JournalReader) WaitEntries(n int) {
+	self.journal.lock.Lock()
+	defer self.journal.lock.Unlock()
+	self.journal.wait(&self.cursor, n)
+}
+
+// Cursor returns the index of the next event to read
+func (self *JournalReader) Cursor() int {
+	self.journal.lock.Lock()
+	defer self.journal.lock.Unlock()
+	return self.cursor
+}
+
+// Seek moves the reader to index n
+func (self *JournalReader) Seek(n int) {
+	self.journal.lock.Lock()
+	defer self.journal.lock.Unlock()
+	self.cursor = n
 }
 
-func NewJournalPlayerController(conf *JournalPlayConfig) Controller {
+type SnapshotConfig struct {
+	Id string
+}
+
+// NewSnapshotController creates a ResourceController responding to GET
+// with a snapshot of the journal, i.e., the minimal journal that recreates
+// the current topology
+func NewSnapshotController(journal *Journal) Controller {
 	self := NewResourceContoller(
 		&ResourceHandlers{
-			// GET /0/players/<playerId>
+			// GET /<networkId>/snapshot
 			Retrieve: &ResourceHandler{
 				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
-					return nil, fmt.Errorf("info about journal player not implemented")
-				},
-			},
-			// DELETE /0/players/<playerId>
-			Destroy: &ResourceHandler{
-				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
-					conf.Journal.Close() // terminate Replay-> TimedRead routine
-					parent.DeleteResource(conf.Id)
-					return empty, nil
+					return Snapshot(msg.(*SnapshotConfig), journal)
 				},
+				Type: reflect.TypeOf(&SnapshotConfig{}),
 			},
 		})
 	return self
 }
 
-type MockerConfig struct {
-	// TODO: frequency/volume etc.
-	Id             string
-	NodeCount      int
-	UpdateInterval time.Duration
+// JournalController serves the journal of a network for download and keeps
+// uploaded journals (in the versioned format, see JournalVersion) so that they
+// can be replayed by journal players
+//
+// GET /<networkId>/journal downloads the network journal
+// POST /<networkId>/journal uploads a journal available as /<networkId>/journal/<journalId>
+type JournalController struct {
+	*ResourceController
+	lock     sync.Mutex
+	journals map[string]*Journal
 }
 
-func NewMockersController(eventer *event.TypeMux) Controller {
-	self := NewResourceContoller(
+func NewJournalController(journal *Journal) *JournalController {
+	self := &JournalController{journals: make(map[string]*Journal)}
+	self.ResourceController = NewResourceContoller(
 		&ResourceHandlers{
-			// Create: n.StartNode, NodeConfig
+			// GET /<networkId>/journal
+			Retrieve: &ResourceHandler{
+				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
+					return encodeJournal(journal)
+				},
+			},
+			// POST /<networkId>/journal
 			Create: &ResourceHandler{
 				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
-					conf := msg.(*MockerConfig)
-					if conf.NodeCount == 0 {
-						conf.NodeCount = 100
+					j := NewJournal()
+					if _, err := j.ReadFrom(bytes.NewReader(msg.([]byte))); err != nil {
+						return nil, err
 					}
-					ids := RandomNodeIds(conf.NodeCount)
-					if conf.UpdateInterval == 0 {
-						conf.UpdateInterval = 1 * time.Second
+					if len(j.Id) == 0 {
+						j.Id = fmt.Sprintf("%d", parent.id)
 					}
-					ticker := time.NewTicker(conf.UpdateInterval)
-					go MockEvents(eventer, ids, ticker.C)
-					c := NewMockerController(conf, ticker)
-					if len(conf.Id) == 0 {
-						conf.Id = fmt.Sprintf("%d", parent.id)
-					}
-					glog.V(6).Infof("new mocker controller on %v", conf.Id)
-					if parent != nil {
-						parent.SetResource(conf.Id, c)
+					if err := self.add(j); err != nil {
+						return nil, err
 					}
 					parent.id++
-					return empty, nil
+					return &struct{ Id string }{j.Id}, nil
 				},
-				Type: reflect.TypeOf(&MockerConfig{}),
+				Type: RawType,
 			},
 		})
 	return self
 }
 
-func NewMockerController(conf *MockerConfig, ticker *time.Ticker) Controller {
-	self := NewResourceContoller(
+// Journal returns the uploaded journal with the given id, nil if not found
+func (self *JournalController) Journal(id string) *Journal {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.journals[id]
+}
+
+func (self *JournalController) add(j *Journal) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if _, found := self.journals[j.Id]; found {
+		return fmt.Errorf("journal %v already exists", j.Id)
+	}
+	self.journals[j.Id] = j
+	self.SetResource(j.Id, NewResourceContoller(
 		&ResourceHandlers{
-			// GET /0/mockevents/<mockerId>
+			// GET /<networkId>/journal/<journalId>
 			Retrieve: &ResourceHandler{
 				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
-					return nil, fmt.Errorf("info about mocker not implemented")
+					return encodeJournal(j)
 				},
 			},
-			// DELETE /0/mockevents/<mockerId>
+			// DELETE /<networkId>/journal/<journalId>
 			Destroy: &ResourceHandler{
 				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
-					ticker.Stop() //terminate MockEvents routine
-					parent.DeleteResource(conf.Id)
+					self.lock.Lock()
+					defer self.lock.Unlock()
+					delete(self.journals, j.Id)
+					self.SetResource(j.Id, nil)
 					return empty, nil
 				},
 			},
-		})
-	return self
+		}))
+	return nil
+}
+
+func encodeJournal(j *Journal) (io.ReadSeeker, error) {
+	buf := &bytes.Buffer{}
+	if _, err := j.WriteTo(buf); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(buf.Bytes()), nil
 }
 
 // deltas: changes in the number of cumulative actions: non-negative integers.
@@ -345,144 +569,3 @@ func ConnLabel(source, target *adapters.NodeId) string {
 	}
 	return fmt.Sprintf("%v-%v", first, second)
 }
-
-// MockEvents generates random connectivity events and posts them
-// to the eventer
-// The journal using the eventer can then be read to visualise or
-// drive connections
-func MockEvents(eventer *event.TypeMux, ids []*adapters.NodeId, ticker <-chan time.Time) {
-
-	var onNodes []*Node
-	offNodes := ids
-	onConnsMap := make(map[string]int)
-	var onConns []*Conn
-	connsMap := make(map[string]int)
-	var conns []*Conn
-	// ids := RandomNodeIds(100)
-	switchonRate := 5
-	dropoutRate := 100
-	newConnCount := 1 // new connection per node per tick
-	connFailRate := 100
-	disconnRate := 100 // fraction of all connections
-	nodesTarget := len(ids) / 2
-	degreeTarget := 8
-	convergenceRate := 5
-	rounds := 0
-	for _ = range ticker {
-		glog.V(6).Infof("rates: %v/%v, %v (%v/%v)", switchonRate, dropoutRate, newConnCount, connFailRate, disconnRate)
-		// here switchon rate will depend
-		nodesUp := len(offNodes) / switchonRate
-		missing := nodesTarget - len(onNodes)
-		if missing > 0 {
-			if nodesUp < missing {
-				nodesUp += (missing-nodesUp)/convergenceRate + 1
-			}
-		}
-
-		nodesDown := len(onNodes) / dropoutRate
-
-		connsUp := len(onNodes) * newConnCount
-		connsUp = connsUp - connsUp/connFailRate
-		missing = nodesTarget*degreeTarget/2 - len(onConns)
-		if missing < connsUp {
-			connsUp = missing
-			if connsUp < 0 {
-				connsUp = 0
-			}
-		}
-		connsDown := len(onConns) / disconnRate
-		glog.V(6).Infof("Nodes Up: %v, Down: %v [ON: %v/%v]\nConns Up: %v, Down: %v [ON: %v/%v(%v)]", nodesUp, nodesDown, len(onNodes), len(onNodes)+len(offNodes), connsUp, connsDown, len(onConns), len(conns)-len(onConns), len(conns))
-
-		for i := 0; len(onNodes) > 0 && i < nodesDown; i++ {
-			c := rand.Intn(len(onNodes))
-			sn := onNodes[c]
-			err := eventer.Post(&NodeEvent{
-				Type:   "node",
-				Action: "down",
-				node:   sn,
-			})
-			if err != nil {
-				panic(err.Error())
-			}
-			onNodes = append(onNodes[0:c], onNodes[c+1:]...)
-			offNodes = append(offNodes, sn.Id)
-		}
-		for i := 0; len(offNodes) > 0 && i < nodesUp; i++ {
-			c := rand.Intn(len(offNodes))
-			sn := &Node{Id: offNodes[c]}
-			err := eventer.Post(&NodeEvent{
-				Type:   "node",
-				Action: "up",
-				node:   sn,
-			})
-			if err != nil {
-				panic(err.Error())
-			}
-			onNodes = append(onNodes, sn)
-			offNodes = append(offNodes[0:c], offNodes[c+1:]...)
-		}
-		var found bool
-		var sc *Conn
-		for i := 0; len(onNodes) > 1 && i < connsUp; i++ {
-			sc = nil
-			n := rand.Intn(len(onNodes) - 1)
-			m := n + 1 + rand.Intn(len(onNodes)-n-1)
-			for i := m; i < len(onNodes); i++ {
-				lab := ConnLabel(onNodes[n].Id, onNodes[i].Id)
-				var j int
-				j, found = onConnsMap[lab]
-				if found {
-					continue
-				}
-				j, found = connsMap[lab]
-				if found {
-					sc = conns[j]
-					break
-				}
-				caller := onNodes[n].Id
-				callee := onNodes[i].Id
-
-				sc := &Conn{
-					One:   caller,
-					Other: callee,
-				}
-				connsMap[lab] = len(conns)
-				conns = append(conns, sc)
-				break
-			}
-
-			if sc == nil {
-				i--
-				continue
-			}
-			lab := ConnLabel(sc.One, sc.Other)
-			onConnsMap[lab] = len(onConns)
-			onConns = append(onConns, sc)
-			err := eventer.Post(&ConnEvent{
-				Type:   "conn",
-				Action: "up",
-				conn:   sc,
-			})
-			if err != nil {
-				panic(err.Error())
-			}
-		}
-
-		for i := 0; len(onConns) > 0 && i < connsDown; i++ {
-			c := rand.Intn(len(onConns))
-			conn := onConns[c]
-			onConns = append(onConns[0:c], onConns[c+1:]...)
-			lab := ConnLabel(conn.One, conn.Other)
-			delete(onConnsMap, lab)
-			err := eventer.Post(&ConnEvent{
-				Type:   "conn",
-				Action: "down",
-				conn:   conn,
-			})
-			if err != nil {
-				panic(err.Error())
-			}
-		}
-		rounds++
-	}
-}
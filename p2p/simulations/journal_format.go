@@ -0,0 +1,212 @@
+package simulations
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p/adapters"
+)
+
+// JournalVersion is the version of the journal format written by Journal.WriteTo
+//
+// The format is JSON lines: the first line is a header
+//
+//   {"version":1,"id":"<journalId>"}
+//
+// followed by one line per event, with the event wrapped in a typed envelope
+//
+//   {"time":"2016-11-14T17:05:21.017272978+02:00","type":"node","data":{...}}
+//
+// the type determines how the data is decoded (see RegisterJournalEvent)
+const JournalVersion = 1
+
+// maximum length of a line in a journal file
+const maxJournalLine = 1024 * 1024
+
+type journalHeader struct {
+	Version int    `json:"version"`
+	Id      string `json:"id"`
+}
+
+type journalEntry struct {
+	Time time.Time       `json:"time"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// event types that can be decoded from journals, indexed by type name
+var journalEvents = map[string]reflect.Type{}
+
+// RegisterJournalEvent registers an event type so that journal entries with
+// the given type name are decoded into it, ev must be a pointer to the zero value
+// of the event type
+func RegisterJournalEvent(name string, ev interface{}) {
+	journalEvents[name] = reflect.TypeOf(ev).Elem()
+}
+
+func init() {
+	RegisterJournalEvent("node", &NodeEvent{})
+	RegisterJournalEvent("conn", &ConnEvent{})
+	RegisterJournalEvent("msg", &adapters.MsgEvent{})
+	RegisterJournalEvent("partition", &PartitionEvent{})
+}
+
+// decodeJournalEvent decodes the JSON encoded event data of type typ
+// the type is read from the data itself if not given
+func decodeJournalEvent(typ string, data []byte) (interface{}, error) {
+	if len(typ) == 0 {
+		var ev struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(data, &ev); err != nil {
+			return nil, err
+		}
+		typ = ev.Type
+	}
+	t, ok := journalEvents[typ]
+	if !ok {
+		return nil, fmt.Errorf("unknown event type '%v'", typ)
+	}
+	val := reflect.New(t)
+	if err := json.Unmarshal(data, val.Interface()); err != nil {
+		return nil, fmt.Errorf("cannot decode %v event: %v", typ, err)
+	}
+	return val.Interface(), nil
+}
+
+// UnmarshalJSON decodes a JSON serialised journal (as produced by encoding/json)
+// so that event data are typed events rather than generic maps
+func (self *Journal) UnmarshalJSON(b []byte) error {
+	var j struct {
+		Id     string
+		Events []struct {
+			Time time.Time
+			Data json.RawMessage
+		}
+	}
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	var events []*event.Event
+	for i, ev := range j.Events {
+		data, err := decodeJournalEvent("", ev.Data)
+		if err != nil {
+			return fmt.Errorf("event %v: %v", i, err)
+		}
+		events = append(events, &event.Event{Time: ev.Time, Data: data})
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.quitc == nil {
+		self.quitc = make(chan bool)
+	}
+	self.Id = j.Id
+	self.Events = events
+	self.counter = len(events)
+	self.cursor = 0
+	self.offset = 0
+	return nil
+}
+
+// WriteTo writes the events of the journal to w in the versioned journal format
+// (see JournalVersion), it implements io.WriterTo
+func (self *Journal) WriteTo(w io.Writer) (int64, error) {
+	self.lock.Lock()
+	events := make([]*event.Event, len(self.Events))
+	copy(events, self.Events)
+	id := self.Id
+	self.lock.Unlock()
+
+	cw := &countingWriter{w: w}
+	enc := json.NewEncoder(cw)
+	if err := enc.Encode(&journalHeader{Version: JournalVersion, Id: id}); err != nil {
+		return cw.n, err
+	}
+	for _, ev := range events {
+		typ := eventType(ev.Data)
+		if _, ok := journalEvents[typ]; !ok {
+			return cw.n, fmt.Errorf("cannot encode event of unknown type %v", typ)
+		}
+		data, err := json.Marshal(ev.Data)
+		if err != nil {
+			return cw.n, err
+		}
+		err = enc.Encode(&journalEntry{Time: ev.Time, Type: typ, Data: data})
+		if err != nil {
+			return cw.n, err
+		}
+	}
+	return cw.n, nil
+}
+
+// ReadFrom reads a journal in the versioned journal format from r and appends
+// its events to the journal, the journal id is set from the header
+// it implements io.ReaderFrom
+func (self *Journal) ReadFrom(r io.Reader) (int64, error) {
+	cr := &countingReader{r: r}
+	scanner := bufio.NewScanner(cr)
+	scanner.Buffer(nil, maxJournalLine)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return cr.n, err
+		}
+		return cr.n, fmt.Errorf("missing journal header")
+	}
+	header := &journalHeader{}
+	if err := json.Unmarshal(scanner.Bytes(), header); err != nil {
+		return cr.n, fmt.Errorf("invalid journal header: %v", err)
+	}
+	if header.Version < 1 || header.Version > JournalVersion {
+		return cr.n, fmt.Errorf("unsupported journal version %v", header.Version)
+	}
+	var events []*event.Event
+	for line := 2; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		entry := &journalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return cr.n, fmt.Errorf("line %v: %v", line, err)
+		}
+		data, err := decodeJournalEvent(entry.Type, entry.Data)
+		if err != nil {
+			return cr.n, fmt.Errorf("line %v: %v", line, err)
+		}
+		events = append(events, &event.Event{Time: entry.Time, Data: data})
+	}
+	if err := scanner.Err(); err != nil {
+		return cr.n, err
+	}
+	self.lock.Lock()
+	self.Id = header.Id
+	self.lock.Unlock()
+	self.append(events...)
+	return cr.n, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (self *countingWriter) Write(b []byte) (int, error) {
+	n, err := self.w.Write(b)
+	self.n += int64(n)
+	return n, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (self *countingReader) Read(b []byte) (int, error) {
+	n, err := self.r.Read(b)
+	self.n += int64(n)
+	return n, err
+}
@@ -1,7 +1,9 @@
 package simulations
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"testing"
 	"time"
@@ -121,9 +123,16 @@ func TestReplay(t *testing.T) {
 	journal.Subscribe(eventer, ConnectivityEvents...)
 
 	Replay(0, jo, eventer)
+	if len(jo.Events) == 0 {
+		t.Fatalf("expected replay to keep the events of the journal")
+	}
+	journal.WaitEntries(len(jo.Events))
+	if len(journal.Events) != len(jo.Events) {
+		t.Fatalf("incorrect number of replayed events: expected %v, got %v", len(jo.Events), len(journal.Events))
+	}
 	for i, ev := range jo.Events {
-		exp := ev.Data.(*NodeEvent).String()
-		got := journal.Events[i].Data.(*NodeEvent).String()
+		exp := fmt.Sprint(ev.Data)
+		got := fmt.Sprint(journal.Events[i].Data)
 		if exp != got {
 			t.Fatalf("incorrent replayed journal entry at pos %v: expected %v, got %v", i, exp, got)
 		}
@@ -139,3 +148,178 @@ func TestReplay(t *testing.T) {
 	// func TestReplay(t *testing.T) {
 	// }
 }
+
+func TestSnapshot(t *testing.T) {
+	ids := RandomNodeIds(3)
+	nodes := make([]*Node, len(ids))
+	for i, id := range ids {
+		nodes[i] = &Node{Id: id}
+	}
+	ab := &Conn{One: ids[0], Other: ids[1]}
+	bc := &Conn{One: ids[1], Other: ids[2], Reverse: true}
+	ac := &Conn{One: ids[0], Other: ids[2]}
+	j := NewJournal()
+	for _, ev := range []interface{}{
+		nodes[0].event(true),
+		nodes[1].event(true),
+		nodes[2].event(true),
+		ab.event(true, false),
+		bc.event(true, true),
+		ac.event(true, false),
+		ab.event(false, false),
+		nodes[2].event(false),
+		ab.event(true, false),
+	} {
+		j.append(&event.Event{Time: time.Now(), Data: ev})
+	}
+	snapshot, err := Snapshot(&SnapshotConfig{Id: "snapshot"}, j)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(j.Events) != 9 || j.Cursor() != 0 {
+		t.Fatalf("snapshot should not consume journal events")
+	}
+	exp := []string{
+		nodes[0].event(true).String(),
+		nodes[1].event(true).String(),
+		ab.event(true, false).String(),
+	}
+	if len(snapshot.Events) != len(exp) {
+		t.Fatalf("incorrect number of snapshot events: expected %v, got %v", len(exp), len(snapshot.Events))
+	}
+	for i, ev := range snapshot.Events {
+		got := fmt.Sprintf("%v", ev.Data)
+		if got != exp[i] {
+			t.Fatalf("incorrect snapshot event at pos %v: expected %v, got %v", i, exp[i], got)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	eventer := &event.TypeMux{}
+	net := NewNetwork(nil, eventer)
+	net.SetNaf(func(conf *NodeConfig) adapters.NodeAdapter {
+		return adapters.NewSimNode(conf.Id, net, &adapters.SimPipe{})
+	})
+	ids := RandomNodeIds(3)
+	j := NewJournal()
+	for _, id := range ids {
+		j.append(&event.Event{Time: time.Now(), Data: (&Node{Id: id}).event(true)})
+	}
+	conn := &Conn{One: ids[0], Other: ids[1], Reverse: true}
+	j.append(&event.Event{Time: time.Now(), Data: conn.event(true, true)})
+
+	if err := net.Load(j); err != nil {
+		t.Fatalf("unexpected error loading snapshot: %v", err)
+	}
+	for _, id := range ids {
+		node := net.GetNode(id)
+		if node == nil || !node.Up {
+			t.Fatalf("node %v not up after load", id)
+		}
+	}
+	got := net.GetConn(ids[0], ids[1])
+	if got == nil || !got.Up {
+		t.Fatalf("conn %v not up after load", conn)
+	}
+	caller := got.One
+	if got.Reverse {
+		caller = got.Other
+	}
+	if caller.NodeID != ids[1].NodeID {
+		t.Fatalf("conn %v should be dialled by %v", got, ids[1])
+	}
+	if c := net.GetConn(ids[1], ids[2]); c != nil && c.Up {
+		t.Fatalf("unexpected conn %v", c)
+	}
+}
+
+func TestWriteReadJournal(t *testing.T) {
+	_, j := loadTestJournal(t)
+	buf := &bytes.Buffer{}
+	if _, err := j.WriteTo(buf); err != nil {
+		t.Fatalf("unexpected error writing journal: %v", err)
+	}
+	jo := NewJournal()
+	if _, err := jo.ReadFrom(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("unexpected error reading journal: %v", err)
+	}
+	if jo.Id != j.Id {
+		t.Fatalf("incorrect journal id: expected %v, got %v", j.Id, jo.Id)
+	}
+	if len(jo.Events) != len(j.Events) {
+		t.Fatalf("incorrect number of events: expected %v, got %v", len(j.Events), len(jo.Events))
+	}
+	for i, ev := range j.Events {
+		exp, _ := json.Marshal(ev)
+		got, _ := json.Marshal(jo.Events[i])
+		if string(exp) != string(got) {
+			t.Fatalf("incorrect event at pos %v: expected %s, got %s", i, exp, got)
+		}
+	}
+
+	_, err := NewJournal().ReadFrom(bytes.NewReader([]byte(`{"version":2,"id":"future"}`)))
+	if err == nil || err.Error() != "unsupported journal version 2" {
+		t.Fatalf("expected unsupported version error, got %v", err)
+	}
+}
+
+func TestJournalReaders(t *testing.T) {
+	j := NewJournal()
+	j.append(testEvents(1, 1, 1)...)
+	r1 := j.NewReader(0)
+	r2 := j.NewReader(j.Counter())
+	if n := r1.Read(func(*event.Event) bool { return true }); n != 3 {
+		t.Fatalf("expected 3 events read, got %v", n)
+	}
+	if r1.NewEntries() != 0 || r2.NewEntries() != 0 || j.NewEntries() != 3 {
+		t.Fatalf("readers interfere: %v, %v, %v", r1.NewEntries(), r2.NewEntries(), j.NewEntries())
+	}
+	j.Read(func(*event.Event) bool { return true })
+	if len(j.Events) != 3 {
+		t.Fatalf("expected history to be kept, got %v events", len(j.Events))
+	}
+
+	// waiting readers are woken up by new events
+	done := make(chan int)
+	go func() {
+		r2.WaitEntries(2)
+		done <- r2.NewEntries()
+	}()
+	j.append(testEvents(1)...)
+	j.append(testEvents(1)...)
+	select {
+	case n := <-done:
+		if n != 2 {
+			t.Fatalf("expected 2 new entries, got %v", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout waiting for entries")
+	}
+
+	// readers skip events discarded by retention
+	j.SetRetention(2)
+	if len(j.Events) != 2 || j.Counter() != 5 {
+		t.Fatalf("expected 2 of 5 events retained, got %v of %v", len(j.Events), j.Counter())
+	}
+	events, from := j.History(0)
+	if len(events) != 2 || from != 3 {
+		t.Fatalf("expected history from 3, got %v events from %v", len(events), from)
+	}
+	r3 := j.NewReader(0)
+	if n := r3.Read(func(*event.Event) bool { return true }); n != 2 || r3.Cursor() != 5 {
+		t.Fatalf("expected 2 events read up to 5, got %v up to %v", n, r3.Cursor())
+	}
+
+	// closing releases waiting readers
+	go func() {
+		r3.WaitEntries(1)
+		close(done)
+	}()
+	j.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("timeout waiting for close")
+	}
+}
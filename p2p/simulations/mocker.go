@@ -0,0 +1,563 @@
+package simulations
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/p2p/adapters"
+)
+
+// MockerConfig configures a mocker generating random connectivity events
+// Strategy selects the way nodes and connections change on each tick
+// (see RegisterMockerStrategy), it defaults to steady state churn
+// rates are given as "1 in N": on each tick 1 in SwitchonRate of the offline
+// nodes come up, 1 in DropoutRate of the online nodes go down etc.
+// zero values are replaced by the defaults, so parameters cannot be set to
+// zero: there is always at least one new connection per node, a degree and
+// nodes target, an event round etc. The random generator is seeded with Seed
+// so that the same config always generates the same events
+type MockerConfig struct {
+	Id             string
+	NodeCount      int
+	UpdateInterval time.Duration
+	Strategy       string
+	Seed           int64
+
+	SwitchonRate    int // 1 in N offline nodes come up per tick
+	DropoutRate     int // 1 in N online nodes go down per tick
+	NewConnCount    int // new connections per online node per tick
+	ConnFailRate    int // 1 in N new connections fail
+	DisconnRate     int // 1 in N connections drop per tick
+	NodesTarget     int // number of online nodes the mocker converges to
+	DegreeTarget    int // average degree the mocker converges to
+	ConvergenceRate int // 1 in N of the missing nodes come up on top of the switchons
+
+	EventRound        int     // tick of the flash crowd, mass departure and hub removal
+	CrowdSize         int     // number of nodes joining in the flash crowd
+	DepartureFraction float64 // fraction of online nodes leaving in the mass departure
+	Period            int     // length of the diurnal cycle in ticks
+	Amplitude         float64 // relative variation of the nodes target over the diurnal cycle
+	HubCount          int     // number of best connected nodes removed
+
+	// drive the nodes and connections of the network instead of posting events
+	Network bool
+}
+
+// defaults fills in the zero values of the config, a zero value cannot be
+// told from a parameter not given
+func (self *MockerConfig) defaults() {
+	if self.NodeCount == 0 {
+		self.NodeCount = 100
+	}
+	if self.UpdateInterval == 0 {
+		self.UpdateInterval = 1 * time.Second
+	}
+	if len(self.Strategy) == 0 {
+		self.Strategy = "churn"
+	}
+	setDefault := func(v *int, d int) {
+		if *v == 0 {
+			*v = d
+		}
+	}
+	setDefault(&self.SwitchonRate, 5)
+	setDefault(&self.DropoutRate, 100)
+	setDefault(&self.NewConnCount, 1)
+	setDefault(&self.ConnFailRate, 100)
+	setDefault(&self.DisconnRate, 100)
+	setDefault(&self.NodesTarget, self.NodeCount/2)
+	setDefault(&self.DegreeTarget, 8)
+	setDefault(&self.ConvergenceRate, 5)
+	setDefault(&self.EventRound, 10)
+	setDefault(&self.CrowdSize, self.NodeCount/4)
+	setDefault(&self.Period, 100)
+	setDefault(&self.HubCount, 1)
+	if self.DepartureFraction == 0 {
+		self.DepartureFraction = 0.5
+	}
+	if self.Amplitude == 0 {
+		self.Amplitude = 0.5
+	}
+}
+
+func (self *MockerConfig) validate() error {
+	if _, ok := mockerStrategies[self.Strategy]; !ok {
+		return fmt.Errorf("unknown mocker strategy '%v'", self.Strategy)
+	}
+	for _, rate := range []int{self.SwitchonRate, self.DropoutRate, self.ConnFailRate, self.DisconnRate, self.ConvergenceRate, self.Period} {
+		if rate < 1 {
+			return fmt.Errorf("invalid rate: %v", rate)
+		}
+	}
+	if self.NodeCount < 0 || self.NewConnCount < 0 || self.NodesTarget < 0 || self.DegreeTarget < 0 || self.CrowdSize < 0 || self.HubCount < 0 {
+		return fmt.Errorf("invalid mocker config: negative count")
+	}
+	if self.DepartureFraction < 0 || self.DepartureFraction > 1 {
+		return fmt.Errorf("invalid departure fraction: %v", self.DepartureFraction)
+	}
+	return nil
+}
+
+// MockerStrategy changes the nodes and connections of the mocker on each tick
+type MockerStrategy func(m *Mocker)
+
+var mockerStrategies = map[string]MockerStrategy{
+	// steady state churn: nodes and connections come and go at the configured
+	// rates while the mocker converges to the nodes and degree targets
+	"churn": func(m *Mocker) {
+		m.Churn(m.conf.NodesTarget)
+	},
+	// flash crowd: CrowdSize nodes join at once on EventRound, then churn
+	// brings the network back to the target
+	"flashcrowd": func(m *Mocker) {
+		if m.round == m.conf.EventRound {
+			m.SwitchOn(m.conf.CrowdSize)
+		}
+		m.Churn(m.conf.NodesTarget)
+	},
+	// mass departure: DepartureFraction of the online nodes leave on EventRound
+	"departure": func(m *Mocker) {
+		if m.round == m.conf.EventRound {
+			m.SwitchOff(int(float64(len(m.onNodes)) * m.conf.DepartureFraction))
+		}
+		m.Churn(m.conf.NodesTarget)
+	},
+	// diurnal load: the nodes target follows a sine wave of the given period
+	"diurnal": func(m *Mocker) {
+		phase := 2 * math.Pi * float64(m.round%m.conf.Period) / float64(m.conf.Period)
+		target := float64(m.conf.NodesTarget) * (1 + m.conf.Amplitude*math.Sin(phase))
+		m.Churn(int(target))
+	},
+	// targeted hub removal: the HubCount best connected nodes go down on EventRound
+	"hubremoval": func(m *Mocker) {
+		if m.round == m.conf.EventRound {
+			m.RemoveHubs(m.conf.HubCount)
+		}
+		m.Churn(m.conf.NodesTarget)
+	},
+}
+
+// RegisterMockerStrategy makes a mocker strategy available under the given name
+func RegisterMockerStrategy(name string, s MockerStrategy) {
+	mockerStrategies[name] = s
+}
+
+// MockerStats are the live statistics of a mocker, Nodes/Conns are the
+// numbers of online nodes and connections, the others count the changes made
+// Errors counts the changes refused by the network
+type MockerStats struct {
+	Strategy  string
+	Rounds    int
+	Nodes     int
+	Conns     int
+	NodesUp   int
+	NodesDown int
+	ConnsUp   int
+	ConnsDown int
+	Errors    int
+}
+
+// mockerBackend carries out the changes generated by a mocker
+type mockerBackend interface {
+	nodeUp(id *adapters.NodeId) error
+	nodeDown(id *adapters.NodeId) error
+	connUp(one, other *adapters.NodeId) error
+	connDown(one, other *adapters.NodeId) error
+}
+
+// eventBackend posts the changes as events without any nodes behind them
+// the events can only be used to visualise or drive connections
+type eventBackend struct {
+	eventer *event.TypeMux
+}
+
+func (self *eventBackend) post(ev interface{}) error {
+	return self.eventer.Post(ev)
+}
+
+func (self *eventBackend) nodeUp(id *adapters.NodeId) error {
+	return self.post(&NodeEvent{Type: "node", Action: "up", node: &Node{Id: id, Up: true}})
+}
+
+func (self *eventBackend) nodeDown(id *adapters.NodeId) error {
+	return self.post(&NodeEvent{Type: "node", Action: "down", node: &Node{Id: id}})
+}
+
+func (self *eventBackend) connUp(one, other *adapters.NodeId) error {
+	return self.post(&ConnEvent{Type: "conn", Action: "up", conn: &Conn{One: one, Other: other, Up: true}})
+}
+
+func (self *eventBackend) connDown(one, other *adapters.NodeId) error {
+	return self.post(&ConnEvent{Type: "conn", Action: "down", conn: &Conn{One: one, Other: other}})
+}
+
+// networkBackend starts and stops the nodes of a network and connects them
+// so that the adapters and protocols experience the changes, the events
+// are posted by the network as the changes actually happen
+type networkBackend struct {
+	net *Network
+}
+
+func (self *networkBackend) nodeUp(id *adapters.NodeId) error {
+	return self.net.Start(id)
+}
+
+func (self *networkBackend) nodeDown(id *adapters.NodeId) error {
+	return self.net.Stop(id)
+}
+
+func (self *networkBackend) connUp(one, other *adapters.NodeId) error {
+	return self.net.Connect(one, other)
+}
+
+func (self *networkBackend) connDown(one, other *adapters.NodeId) error {
+	return self.net.Disconnect(one, other, true)
+}
+
+// Mocker generates random connectivity events over a fixed set of node ids
+// and posts them to the eventer
+type Mocker struct {
+	conf     *MockerConfig
+	backend  mockerBackend
+	strategy MockerStrategy
+	rand     *rand.Rand
+	lock     sync.Mutex
+	round    int
+	onNodes  []*adapters.NodeId
+	offNodes []*adapters.NodeId
+	onConns  []*Conn
+	stats    MockerStats
+	quitc    chan bool
+	stopOnce sync.Once
+}
+
+// NewMocker creates a mocker posting events for the given node ids to the
+// eventer, all nodes start offline
+func NewMocker(eventer *event.TypeMux, ids []*adapters.NodeId, conf *MockerConfig) (*Mocker, error) {
+	return newMocker(&eventBackend{eventer}, ids, conf)
+}
+
+// NewNetworkMocker creates a mocker driving the network: NodeCount new nodes
+// are added to the network (using its node adapter function) and the mocker
+// starts, stops, connects and disconnects them
+func NewNetworkMocker(net *Network, conf *MockerConfig) (*Mocker, error) {
+	conf.defaults()
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	var ids []*adapters.NodeId
+	for i := 0; i < conf.NodeCount; i++ {
+		node := RandomNodeConfig()
+		if err := net.NewNode(node); err != nil {
+			return nil, err
+		}
+		ids = append(ids, node.Id)
+	}
+	return newMocker(&networkBackend{net}, ids, conf)
+}
+
+func newMocker(backend mockerBackend, ids []*adapters.NodeId, conf *MockerConfig) (*Mocker, error) {
+	conf.defaults()
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	return &Mocker{
+		conf:     conf,
+		backend:  backend,
+		strategy: mockerStrategies[conf.Strategy],
+		rand:     rand.New(rand.NewSource(conf.Seed)),
+		offNodes: append([]*adapters.NodeId{}, ids...),
+		stats:    MockerStats{Strategy: conf.Strategy},
+		quitc:    make(chan bool),
+	}, nil
+}
+
+// Run applies the strategy on every tick until the ticker channel is closed
+// or the mocker is stopped
+func (self *Mocker) Run(ticker <-chan time.Time) {
+	for {
+		select {
+		case _, ok := <-ticker:
+			if !ok {
+				return
+			}
+			self.Tick()
+		case <-self.quitc:
+			return
+		}
+	}
+}
+
+// Stop terminates Run
+func (self *Mocker) Stop() {
+	self.stopOnce.Do(func() { close(self.quitc) })
+}
+
+// Tick applies the strategy once
+func (self *Mocker) Tick() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.strategy(self)
+	self.round++
+	self.stats.Rounds = self.round
+	self.stats.Nodes = len(self.onNodes)
+	self.stats.Conns = len(self.onConns)
+}
+
+// Stats returns a copy of the live statistics of the mocker
+func (self *Mocker) Stats() *MockerStats {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	stats := self.stats
+	return &stats
+}
+
+// Churn switches nodes and connections on and off at the configured rates
+// converging to the given number of online nodes and the degree target
+func (self *Mocker) Churn(nodesTarget int) {
+	c := self.conf
+	nodesUp := len(self.offNodes) / c.SwitchonRate
+	nodesDown := len(self.onNodes) / c.DropoutRate
+	missing := nodesTarget - len(self.onNodes)
+	if missing > 0 && nodesUp < missing {
+		nodesUp += (missing-nodesUp)/c.ConvergenceRate + 1
+	}
+	if missing < 0 {
+		nodesUp = 0
+		nodesDown += -missing/c.ConvergenceRate + 1
+	}
+
+	connsUp := len(self.onNodes) * c.NewConnCount
+	connsUp = connsUp - connsUp/c.ConnFailRate
+	missing = nodesTarget*c.DegreeTarget/2 - len(self.onConns)
+	if missing < connsUp {
+		connsUp = missing
+		if connsUp < 0 {
+			connsUp = 0
+		}
+	}
+	connsDown := len(self.onConns) / c.DisconnRate
+	glog.V(6).Infof("nodes up: %v, down: %v [on: %v/%v], conns up: %v, down: %v [on: %v]", nodesUp, nodesDown, len(self.onNodes), len(self.onNodes)+len(self.offNodes), connsUp, connsDown, len(self.onConns))
+
+	self.SwitchOff(nodesDown)
+	self.SwitchOn(nodesUp)
+	self.Connect(connsUp)
+	self.Disconnect(connsDown)
+}
+
+// SwitchOn brings up n random offline nodes
+func (self *Mocker) SwitchOn(n int) {
+	for i := 0; len(self.offNodes) > 0 && i < n; i++ {
+		c := self.rand.Intn(len(self.offNodes))
+		id := self.offNodes[c]
+		if self.failed(self.backend.nodeUp(id)) {
+			continue
+		}
+		self.offNodes = append(self.offNodes[:c], self.offNodes[c+1:]...)
+		self.onNodes = append(self.onNodes, id)
+		self.stats.NodesUp++
+	}
+}
+
+// SwitchOff takes down n random online nodes
+func (self *Mocker) SwitchOff(n int) {
+	for i := 0; len(self.onNodes) > 0 && i < n; i++ {
+		self.nodeDown(self.rand.Intn(len(self.onNodes)))
+	}
+}
+
+// RemoveHubs takes down the n online nodes with the most connections
+func (self *Mocker) RemoveHubs(n int) {
+	degrees := make(map[string]int)
+	for _, conn := range self.onConns {
+		degrees[conn.One.String()]++
+		degrees[conn.Other.String()]++
+	}
+	hubs := &byDegree{append([]*adapters.NodeId{}, self.onNodes...), degrees}
+	sort.Stable(hubs)
+	for i := 0; i < n && i < len(hubs.ids); i++ {
+		for c, id := range self.onNodes {
+			if id == hubs.ids[i] {
+				self.nodeDown(c)
+				break
+			}
+		}
+	}
+}
+
+// byDegree sorts node ids by decreasing number of connections
+type byDegree struct {
+	ids     []*adapters.NodeId
+	degrees map[string]int
+}
+
+func (self *byDegree) Len() int      { return len(self.ids) }
+func (self *byDegree) Swap(i, j int) { self.ids[i], self.ids[j] = self.ids[j], self.ids[i] }
+func (self *byDegree) Less(i, j int) bool {
+	return self.degrees[self.ids[i].String()] > self.degrees[self.ids[j].String()]
+}
+
+// nodeDown takes down the online node of the given index and its connections
+// the node is considered offline even if the network fails to stop it
+func (self *Mocker) nodeDown(c int) {
+	id := self.onNodes[c]
+	for i := 0; i < len(self.onConns); {
+		if conn := self.onConns[i]; conn.One == id || conn.Other == id {
+			self.connDown(i)
+			continue
+		}
+		i++
+	}
+	self.onNodes = append(self.onNodes[:c], self.onNodes[c+1:]...)
+	self.offNodes = append(self.offNodes, id)
+	if !self.failed(self.backend.nodeDown(id)) {
+		self.stats.NodesDown++
+	}
+}
+
+// Connect establishes n new connections between random online nodes
+// it stops early if there are not enough unconnected pairs
+func (self *Mocker) Connect(n int) {
+	connected := make(map[string]bool)
+	for _, conn := range self.onConns {
+		connected[ConnLabel(conn.One, conn.Other)] = true
+	}
+	for i := 0; i < n; i++ {
+		pairs := len(self.onNodes) * (len(self.onNodes) - 1) / 2
+		if len(connected) >= pairs {
+			return
+		}
+		var one, other *adapters.NodeId
+		for {
+			j := self.rand.Intn(len(self.onNodes))
+			k := self.rand.Intn(len(self.onNodes))
+			one, other = self.onNodes[j], self.onNodes[k]
+			if j != k && !connected[ConnLabel(one, other)] {
+				break
+			}
+		}
+		connected[ConnLabel(one, other)] = true
+		if self.failed(self.backend.connUp(one, other)) {
+			continue
+		}
+		self.onConns = append(self.onConns, &Conn{One: one, Other: other, Up: true})
+		self.stats.ConnsUp++
+	}
+}
+
+// Disconnect drops n random connections
+func (self *Mocker) Disconnect(n int) {
+	for i := 0; len(self.onConns) > 0 && i < n; i++ {
+		self.connDown(self.rand.Intn(len(self.onConns)))
+	}
+}
+
+func (self *Mocker) connDown(c int) {
+	conn := self.onConns[c]
+	self.onConns = append(self.onConns[:c], self.onConns[c+1:]...)
+	if !self.failed(self.backend.connDown(conn.One, conn.Other)) {
+		self.stats.ConnsDown++
+	}
+}
+
+// failed counts and logs the error of a change
+func (self *Mocker) failed(err error) bool {
+	if err == nil {
+		return false
+	}
+	glog.V(6).Infof("mocker change failed: %v", err)
+	self.stats.Errors++
+	return true
+}
+
+// MockEvents generates random connectivity events with the default steady
+// state churn and posts them to the eventer
+// The journal using the eventer can then be read to visualise or
+// drive connections
+func MockEvents(eventer *event.TypeMux, ids []*adapters.NodeId, ticker <-chan time.Time) {
+	m, err := NewMocker(eventer, ids, &MockerConfig{Seed: time.Now().UnixNano()})
+	if err != nil {
+		panic(err.Error())
+	}
+	m.Run(ticker)
+}
+
+// NewMockersController creates a ResourceController for mockers generating
+// random events or driving the network (see MockerConfig.Network), mockers
+// tick on the clock of the network
+func NewMockersController(eventer *event.TypeMux, net *Network) Controller {
+	self := NewResourceContoller(
+		&ResourceHandlers{
+			// POST /<networkId>/mockevents
+			Create: &ResourceHandler{
+				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
+					conf := msg.(*MockerConfig)
+					conf.defaults()
+					// the mocker is only started once its id is taken, a
+					// duplicate id is an error
+					err := parent.NewResource(conf.Id, func(id string) (Controller, error) {
+						conf.Id = id
+						var mocker *Mocker
+						var err error
+						if conf.Network {
+							mocker, err = NewNetworkMocker(net, conf)
+						} else {
+							mocker, err = NewMocker(eventer, RandomNodeIds(conf.NodeCount), conf)
+						}
+						if err != nil {
+							return nil, err
+						}
+						ticker := mclock.NewTicker(net.Clock(), conf.UpdateInterval)
+						go mocker.Run(ticker.C)
+						go func() {
+							select {
+							case <-net.Done():
+								ticker.Stop()
+								mocker.Stop()
+							case <-mocker.quitc:
+								ticker.Stop()
+							}
+						}()
+						return NewMockerController(conf, mocker, ticker), nil
+					})
+					if err != nil {
+						return nil, err
+					}
+					glog.V(6).Infof("new mocker controller on %v", conf.Id)
+					return empty, nil
+				},
+				Type: reflect.TypeOf(&MockerConfig{}),
+			},
+		})
+	return self
+}
+
+func NewMockerController(conf *MockerConfig, mocker *Mocker, ticker *mclock.Ticker) Controller {
+	self := NewResourceContoller(
+		&ResourceHandlers{
+			// GET /<networkId>/mockevents/<mockerId>
+			Retrieve: &ResourceHandler{
+				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
+					return mocker.Stats(), nil
+				},
+			},
+			// DELETE /<networkId>/mockevents/<mockerId>
+			Destroy: &ResourceHandler{
+				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
+					ticker.Stop()
+					mocker.Stop() // terminate Run routine
+					parent.detach()
+					return empty, nil
+				},
+			},
+		})
+	return self
+}
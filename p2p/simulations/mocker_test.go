@@ -0,0 +1,166 @@
+package simulations
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p/adapters"
+)
+
+func runMocker(t *testing.T, conf *MockerConfig, rounds int) (*Mocker, []*MockerStats) {
+	m, err := NewMocker(&event.TypeMux{}, RandomNodeIds(conf.NodeCount), conf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var stats []*MockerStats
+	for i := 0; i < rounds; i++ {
+		m.Tick()
+		stats = append(stats, m.Stats())
+	}
+	return m, stats
+}
+
+func TestMockerStrategies(t *testing.T) {
+	for _, strategy := range []string{"churn", "flashcrowd", "departure", "diurnal", "hubremoval"} {
+		conf := &MockerConfig{NodeCount: 40, Strategy: strategy, Seed: 1, Period: 20}
+		m, stats := runMocker(t, conf, 40)
+		_, again := runMocker(t, &MockerConfig{NodeCount: 40, Strategy: strategy, Seed: 1, Period: 20}, 40)
+		if !reflect.DeepEqual(stats[len(stats)-1], again[len(again)-1]) {
+			t.Fatalf("%v: expected the same stats for the same seed, got %v and %v", strategy, stats[len(stats)-1], again[len(again)-1])
+		}
+		last := stats[len(stats)-1]
+		if last.Rounds != 40 || last.Nodes != len(m.onNodes) || last.Conns != len(m.onConns) {
+			t.Fatalf("%v: inconsistent stats %v", strategy, last)
+		}
+		if last.NodesUp-last.NodesDown != last.Nodes || last.ConnsUp-last.ConnsDown != last.Conns {
+			t.Fatalf("%v: event counts do not add up: %v", strategy, last)
+		}
+		for _, conn := range m.onConns {
+			var one, other bool
+			for _, id := range m.onNodes {
+				one = one || id == conn.One
+				other = other || id == conn.Other
+			}
+			if !one || !other {
+				t.Fatalf("%v: connection %v between offline nodes", strategy, ConnLabel(conn.One, conn.Other))
+			}
+		}
+	}
+}
+
+func TestMockerEvents(t *testing.T) {
+	conf := &MockerConfig{NodeCount: 100, EventRound: 20}
+	before, after := func(s []*MockerStats) *MockerStats { return s[conf.EventRound-1] }, func(s []*MockerStats) *MockerStats { return s[conf.EventRound] }
+
+	conf.Strategy = "flashcrowd"
+	_, stats := runMocker(t, conf, 21)
+	if up := after(stats).NodesUp - before(stats).NodesUp; up < conf.CrowdSize {
+		t.Fatalf("flashcrowd: expected at least %v nodes up, got %v", conf.CrowdSize, up)
+	}
+
+	conf.Strategy = "departure"
+	_, stats = runMocker(t, conf, 21)
+	if down := after(stats).NodesDown - before(stats).NodesDown; down < before(stats).Nodes/2 {
+		t.Fatalf("departure: expected at least %v nodes down, got %v", before(stats).Nodes/2, down)
+	}
+
+	conf.Strategy = "hubremoval"
+	conf.HubCount = 3
+	m, _ := runMocker(t, conf, conf.EventRound)
+	degrees := make(map[string]int)
+	for _, conn := range m.onConns {
+		degrees[conn.One.String()]++
+		degrees[conn.Other.String()]++
+	}
+	m.RemoveHubs(conf.HubCount)
+	for _, id := range m.onNodes {
+		for _, gone := range m.offNodes[len(m.offNodes)-conf.HubCount:] {
+			if degrees[id.String()] > degrees[gone.String()] {
+				t.Fatalf("hubremoval: node with degree %v removed while node with degree %v remains", degrees[gone.String()], degrees[id.String()])
+			}
+		}
+	}
+}
+
+func TestMockerConfig(t *testing.T) {
+	if _, err := NewMocker(&event.TypeMux{}, nil, &MockerConfig{Strategy: "unknown"}); err == nil {
+		t.Fatalf("expected error for unknown strategy")
+	}
+	if _, err := NewMocker(&event.TypeMux{}, nil, &MockerConfig{DropoutRate: -1}); err == nil {
+		t.Fatalf("expected error for invalid rate")
+	}
+}
+
+func TestNetworkMocker(t *testing.T) {
+	net := NewNetwork(nil, &event.TypeMux{})
+	net.SetNaf(func(conf *NodeConfig) adapters.NodeAdapter {
+		return adapters.NewSimNode(conf.Id, net, &adapters.SimPipe{})
+	})
+	m, err := NewNetworkMocker(net, &MockerConfig{NodeCount: 20, Seed: 1, DropoutRate: 10, DisconnRate: 10, Network: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(net.GetNodes()) != 20 {
+		t.Fatalf("expected 20 nodes in the network, got %v", len(net.GetNodes()))
+	}
+	for i := 0; i < 30; i++ {
+		m.Tick()
+	}
+	stats := m.Stats()
+	if stats.Errors > 0 {
+		t.Fatalf("unexpected errors: %v", stats)
+	}
+	if stats.NodesDown == 0 || stats.ConnsDown == 0 {
+		t.Fatalf("expected churn, got %v", stats)
+	}
+	var up int
+	for _, node := range net.GetNodes() {
+		if node.Up {
+			up++
+		}
+	}
+	if up != stats.Nodes {
+		t.Fatalf("expected %v nodes up in the network, got %v", stats.Nodes, up)
+	}
+	for _, conn := range m.onConns {
+		if c := net.GetConn(conn.One, conn.Other); c == nil || !c.Up {
+			t.Fatalf("expected %v to be connected in the network", ConnLabel(conn.One, conn.Other))
+		}
+	}
+	var conns int
+	for _, conn := range net.GetConns() {
+		if conn.Up {
+			conns++
+		}
+	}
+	if conns != stats.Conns {
+		t.Fatalf("expected %v connections up in the network, got %v", stats.Conns, conns)
+	}
+}
+
+func TestMockersControllerDuplicate(t *testing.T) {
+	eventer := &event.TypeMux{}
+	net := NewNetwork(nil, eventer)
+	defer net.Shutdown()
+	c := NewMockersController(eventer, net)
+	h, err := c.Handle("POST")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conf := []byte(`{"Id": "mocker", "NodeCount": 4, "UpdateInterval": 3600000000000}`)
+	if _, err := h(bytes.NewReader(conf)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m, err := c.Resource("mocker")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := h(bytes.NewReader(conf)); err == nil {
+		t.Fatalf("expected error creating mocker with the same id")
+	}
+	if n, _ := c.Resource("mocker"); n != m {
+		t.Fatalf("expected the running mocker to be kept")
+	}
+}
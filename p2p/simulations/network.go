@@ -27,34 +27,120 @@
 package simulations
 
 import (
+	"crypto/ecdsa"
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"sync"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common/mclock"
 	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/ethereum/go-ethereum/p2p/adapters"
 	"github.com/ethereum/go-ethereum/p2p/discover"
 )
 
+// NetworkConfig configures a network created by the session controller
+// Adapter selects the node adapter ("sim" by default, see RegisterNodeAdapter)
+// configured by AdapterConfig, nodes run the protocol registered under the
+// name Protocol configured by ProtocolConfig (see adapters.RegisterProtocol)
+// Topology is the initial topology built with the topology resource of the
+// network (the topology package needs to be imported, see topology.Config)
 type NetworkConfig struct {
-	// Type   NetworkType
-	// Config json.RawMessage // type-specific configs
-	// type
 	// Events []string
-	Id string
+	Id             string
+	Trace          bool // trace messages sent between nodes (see adapters.MsgEvent)
+	Adapter        string
+	AdapterConfig  json.RawMessage `json:",omitempty"`
+	Protocol       string          `json:",omitempty"`
+	ProtocolConfig json.RawMessage `json:",omitempty"`
+	Topology       json.RawMessage `json:",omitempty"`
+}
+
+// event types related to connectivity, i.e., nodes coming on dropping off,
+// connections established and dropped and the network partitioned and healed
+var ConnectivityEvents = []interface{}{&NodeEvent{}, &ConnEvent{}, &PartitionEvent{}}
+
+// event types related to traffic, i.e., messages sent between nodes
+var MsgEvents = []interface{}{&adapters.MsgEvent{}}
+
+// constructors of network resources registered by other packages
+var networkResources = make(map[string]func(*Network) Controller)
+
+// RegisterNetworkResource registers a resource that network controllers serve as
+// /<networkId>/<name>, used by packages extending the API of the network controller
+// (see the topology package). It is meant to be called from init functions.
+func RegisterNetworkResource(name string, f func(*Network) Controller) {
+	networkResources[name] = f
+}
+
+// NetworkController is the controller of a network hosted by the session controller
+type NetworkController struct {
+	*ResourceController
+	conf    *NetworkConfig
+	net     *Network
+	journal *Journal
+}
+
+// NetworkInfo summarises the state of a network, Status is "running" if any
+// node is up, "idle" otherwise and "shutdown" once the network is shut down
+type NetworkInfo struct {
+	Id      string
+	Nodes   int
+	NodesUp int
+	Conns   int
+	ConnsUp int
+	Status  string
+}
+
+// Info returns the summary of the network
+func (self *NetworkController) Info() *NetworkInfo {
+	info := &NetworkInfo{Id: self.conf.Id, Status: "idle"}
+	// the state of nodes and connections is read under the lock of the network
+	self.net.lock.Lock()
+	for _, node := range self.net.Nodes {
+		info.Nodes++
+		if node.Up {
+			info.NodesUp++
+			info.Status = "running"
+		}
+	}
+	for _, conn := range self.net.Conns {
+		info.Conns++
+		if conn.Up {
+			info.ConnsUp++
+		}
+	}
+	self.net.lock.Unlock()
+	select {
+	case <-self.net.Done():
+		info.Status = "shutdown"
+	default:
+	}
+	return info
 }
 
-// event types related to connectivity, i.e., nodes coming on dropping off
-// and connections established and dropped
-var ConnectivityEvents = []interface{}{&NodeEvent{}, &ConnEvent{}}
+// Shutdown stops the nodes of the network and closes its journal
+func (self *NetworkController) Shutdown() error {
+	err := self.net.Shutdown()
+	self.journal.Close()
+	return err
+}
 
 // NewNetworkController creates a ResourceController responding to GET and DELETE methods
-// it embeds a mockers controller, a journal player, node and connection contollers.
+// it embeds a mockers controller, a journal player, node and connection contollers
+// (connections are sub resources of nodes: /<networkId>/nodes/<nodeId>/conns/<peerId>).
+// DELETE shuts the network down (see Network.Shutdown), closes the journal
+// and removes the network from the session
 //
 // Events from the eventer go into the provided journal. The content of the journal can be
 // accessed through the HTTP API.
-func NewNetworkController(conf *NetworkConfig, eventer *event.TypeMux, journal *Journal) Controller {
+func NewNetworkController(conf *NetworkConfig, net *Network, journal *Journal) Controller {
+	eventer := net.Events()
+	nc := &NetworkController{conf: conf, net: net, journal: journal}
+	// the cytoscape view reads the journal with its own cursor
+	cyReader := journal.NewReader(0)
 	self := NewResourceContoller(
 		&ResourceHandlers{
 			// GET /<networkId>/
@@ -63,32 +149,45 @@ func NewNetworkController(conf *NetworkConfig, eventer *event.TypeMux, journal *
 					glog.V(6).Infof("msg: %v", msg)
 					cyConfig, ok := msg.(*CyConfig)
 					if ok {
-						return UpdateCy(cyConfig, journal)
-					}
-					snapshotConfig, ok := msg.(*SnapshotConfig)
-					if ok {
-						return Snapshot(snapshotConfig, journal)
+						return UpdateCy(cyConfig, cyReader)
 					}
-					return nil, fmt.Errorf("invalId json body: must be CyConfig or SnapshotConfig")
+					return nil, fmt.Errorf("invalId json body: must be CyConfig")
 				},
 				Type: reflect.TypeOf(&CyConfig{}),
 			},
 			// DELETE /<networkId>/
 			Destroy: &ResourceHandler{
 				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
-					parent.DeleteResource(conf.Id)
-					return nil, nil
+					// the network is shut down even if some nodes fail to stop
+					err := nc.Shutdown()
+					parent.detach()
+					return empty, err
 				},
 			},
 		},
 	)
 	// subscribe to all event entries (generated)
-	journal.Subscribe(eventer, ConnectivityEvents...)
-	// self.SetResource("nodes", NewNodesController(eventer))
-	// self.SetResource("connections", NewConnectionsController(eventer))
-	self.SetResource("mockevents", NewMockersController(eventer))
-	self.SetResource("journals", NewJournalPlayersController(eventer))
-	return Controller(self)
+	var events []interface{}
+	events = append(events, ConnectivityEvents...)
+	events = append(events, MsgEvents...)
+	journal.SetClock(net.Clock())
+	journal.Subscribe(eventer, events...)
+	self.SetResource("nodes", NewNodesController(net))
+	self.SetResource("snapshot", NewSnapshotController(journal))
+	self.SetResource("query", NewQueryController(journal))
+	self.SetResource("partition", NewPartitionController(net))
+	self.SetResource("report", NewReportController(net))
+	self.SetResource("events", NewEventsController(eventer, journal, events...))
+	self.SetResource("mockevents", NewMockersController(eventer, net))
+	journals := NewJournalController(journal)
+	self.SetResource("journal", journals)
+	self.SetResource("journals", NewJournalPlayersController(eventer, journals, net))
+	self.SetResource("graph", NewGraphController(conf.Id, net, journal, journals))
+	for name, f := range networkResources {
+		self.SetResource(name, f(net))
+	}
+	nc.ResourceController = self
+	return nc
 }
 
 // Network models a p2p network
@@ -108,21 +207,80 @@ type Network struct {
 	// node adapter function that creates the node model for
 	// the particular type of network from a config
 	naf func(*NodeConfig) adapters.NodeAdapter
+	// group index of partitioned nodes (see Partition)
+	partition map[discover.NodeID]int
+	// clock of the simulation, nil means wall clock time
+	clock mclock.Clock
+	// closed on shutdown
+	quitc        chan struct{}
+	shutdownOnce sync.Once
 }
 
 func NewNetwork(triggers, events *event.TypeMux) *Network {
+	if events == nil {
+		events = &event.TypeMux{}
+	}
 	return &Network{
 		triggers: triggers,
 		events:   events,
 		nodeMap:  make(map[discover.NodeID]int),
 		connMap:  make(map[string]int),
+		quitc:    make(chan struct{}),
+	}
+}
+
+// Done returns a channel closed when the network is shut down, mockers and
+// journal players driving the network stop then
+func (self *Network) Done() <-chan struct{} {
+	return self.quitc
+}
+
+// Shutdown disconnects and stops all nodes of the network, it returns the
+// first error encountered but carries on with the other nodes
+func (self *Network) Shutdown() error {
+	self.shutdownOnce.Do(func() { close(self.quitc) })
+	var err error
+	for _, conn := range self.GetConns() {
+		if !conn.Up {
+			continue
+		}
+		if derr := self.Disconnect(conn.One, conn.Other, true); derr != nil && err == nil {
+			err = derr
+		}
+	}
+	for _, node := range self.GetNodes() {
+		if !node.Up {
+			continue
+		}
+		if serr := self.Stop(node.Id); serr != nil && err == nil {
+			err = serr
+		}
 	}
+	glog.V(6).Infof("network shut down")
+	return err
 }
 
 func (self *Network) SetNaf(naf func(*NodeConfig) adapters.NodeAdapter) {
 	self.naf = naf
 }
 
+// SetClock sets the clock the network runs on, with a simulated clock
+// (mclock.Simulated) events are timestamped in virtual time and timers of the
+// nodes, the journal and the mockers of the network controller run in virtual time
+// it must be set before nodes are added and subscriptions to the events are made
+func (self *Network) SetClock(clock mclock.Clock) {
+	self.clock = clock
+	self.events.Now = func() time.Time { return clock.Now().Time() }
+}
+
+// Clock returns the clock the network runs on (implements adapters.ClockNetwork)
+func (self *Network) Clock() mclock.Clock {
+	if self.clock == nil {
+		return mclock.System{}
+	}
+	return self.clock
+}
+
 // Events returns the output eventer of the Network.
 func (self *Network) Events() *event.TypeMux {
 	return self.events
@@ -152,7 +310,10 @@ type NodeEvent struct {
 type ConnEvent struct {
 	Action string
 	Type   string
-	conn   *Conn
+	// time the change was observed by the node reporting it, zero unless
+	// the connection was reported by a node outside the simulation (see Report)
+	Reported time.Time
+	conn     *Conn
 }
 
 func (self *ConnEvent) String() string {
@@ -163,6 +324,80 @@ func (self *NodeEvent) String() string {
 	return fmt.Sprintf("<Action: %v, Type: %v, Data: %v>\n", self.Action, self.Type, self.node)
 }
 
+// eventJSON is the JSON representation of node and connection events,
+// the object is the node or the connection the action applies to
+type eventJSON struct {
+	Action string      `json:"action"`
+	Object interface{} `json:"object"`
+	Type   string      `json:"type"`
+}
+
+type nodeJSON struct {
+	Id *adapters.NodeId `json:"id"`
+}
+
+type connJSON struct {
+	Callee   *adapters.NodeId `json:"callee"`
+	Caller   *adapters.NodeId `json:"caller"`
+	Reported *time.Time       `json:"reported,omitempty"`
+}
+
+func (self *NodeEvent) MarshalJSON() ([]byte, error) {
+	var obj *nodeJSON
+	if self.node != nil {
+		obj = &nodeJSON{Id: self.node.Id}
+	}
+	return json.Marshal(&eventJSON{Action: self.Action, Object: obj, Type: self.Type})
+}
+
+func (self *ConnEvent) MarshalJSON() ([]byte, error) {
+	var obj *connJSON
+	if self.conn != nil {
+		// the caller is the node that dialled (or dropped) the connection
+		obj = &connJSON{Caller: self.conn.One, Callee: self.conn.Other}
+		if self.conn.Reverse {
+			obj.Caller, obj.Callee = obj.Callee, obj.Caller
+		}
+		if !self.Reported.IsZero() {
+			obj.Reported = &self.Reported
+		}
+	}
+	return json.Marshal(&eventJSON{Action: self.Action, Object: obj, Type: self.Type})
+}
+
+func (self *NodeEvent) UnmarshalJSON(b []byte) error {
+	obj := &nodeJSON{}
+	ev := &eventJSON{Object: obj}
+	if err := json.Unmarshal(b, ev); err != nil {
+		return err
+	}
+	if obj.Id == nil {
+		return fmt.Errorf("missing node id in %v event", ev.Type)
+	}
+	self.Action = ev.Action
+	self.Type = ev.Type
+	self.node = &Node{Id: obj.Id, Up: ev.Action == "up"}
+	return nil
+}
+
+func (self *ConnEvent) UnmarshalJSON(b []byte) error {
+	obj := &connJSON{}
+	ev := &eventJSON{Object: obj}
+	if err := json.Unmarshal(b, ev); err != nil {
+		return err
+	}
+	if obj.Caller == nil || obj.Callee == nil {
+		return fmt.Errorf("missing caller or callee in %v event", ev.Type)
+	}
+	self.Action = ev.Action
+	self.Type = ev.Type
+	if obj.Reported != nil {
+		self.Reported = *obj.Reported
+	}
+	self.conn = &Conn{One: obj.Caller, Other: obj.Callee, Up: ev.Action == "up"}
+	return nil
+}
+
 func (self *Node) event(up bool) *NodeEvent {
 	var action string
 	if up {
@@ -188,6 +423,8 @@ type Conn struct {
 	Up bool `json:"up"`
 	// reverse is false by default (One dialled/dropped the Other)
 	Reverse bool `json:"reverse"`
+	// quality of the link, takes effect when the connection is established
+	Link *adapters.LinkProfile `json:"link,omitempty"`
 	// Info
 	// average throughput, recent average throughput etc
 }
@@ -212,11 +449,10 @@ func (self *Conn) event(up, rev bool) *ConnEvent {
 
 type NodeConfig struct {
 	Id *adapters.NodeId `json:"Id"`
-}
-
-// TODO: ignored for now
-type QueryConfig struct {
-	Format string // "cy.update", "journal",
+	// key of the node, needed by adapters running a p2p.Server
+	PrivateKey *ecdsa.PrivateKey `json:"-"`
+	// default quality of the links of connections the node dials
+	Link *adapters.LinkProfile `json:"Link,omitempty"`
 }
 
 type Know struct {
@@ -241,8 +477,11 @@ func (self *Network) NewNode(conf *NodeConfig) error {
 	if found {
 		return fmt.Errorf("node %v already added", id)
 	}
-	self.nodeMap[id.NodeID] = len(self.Nodes)
 	na := self.naf(conf)
+	if na == nil {
+		return fmt.Errorf("cannot create adapter of node %v", id)
+	}
+	self.nodeMap[id.NodeID] = len(self.Nodes)
 	node := &Node{
 		Id:     conf.Id,
 		config: conf,
@@ -253,6 +492,133 @@ func (self *Network) NewNode(conf *NodeConfig) error {
 	return nil
 }
 
+// Load recreates the nodes and connections described by a snapshot journal
+// (see Snapshot) using the node adapter function of the network
+// nodes are created if they do not exist and started if they are down,
+// connections are established in the direction they were originally dialled
+func (self *Network) Load(snapshot *Journal) error {
+	snapshot.lock.Lock()
+	events := make([]*event.Event, len(snapshot.Events))
+	copy(events, snapshot.Events)
+	snapshot.lock.Unlock()
+
+	for _, ev := range events {
+		switch e := ev.Data.(type) {
+		case *NodeEvent:
+			if e.Action != "up" {
+				continue
+			}
+			id := e.node.Id
+			node := self.GetNode(id)
+			if node == nil {
+				conf := e.node.config
+				if conf == nil {
+					conf = &NodeConfig{Id: id}
+				}
+				if err := self.NewNode(conf); err != nil {
+					return err
+				}
+				node = self.GetNode(id)
+			}
+			if node.Up {
+				continue
+			}
+			if err := self.Start(id); err != nil {
+				return fmt.Errorf("cannot start node %v: %v", id, err)
+			}
+		case *ConnEvent:
+			if e.Action != "up" {
+				continue
+			}
+			one, other := e.conn.One, e.conn.Other
+			if e.conn.Reverse {
+				one, other = other, one
+			}
+			if conn := self.GetConn(one, other); conn != nil && conn.Up {
+				continue
+			}
+			if err := self.Connect(one, other); err != nil {
+				return fmt.Errorf("cannot connect %v to %v: %v", one, other, err)
+			}
+		case *PartitionEvent:
+			if e.Action != "partition" {
+				continue
+			}
+			if err := self.Partition(e.Groups...); err != nil {
+				return fmt.Errorf("cannot partition network: %v", err)
+			}
+		}
+	}
+	glog.V(6).Infof("loaded snapshot %v (%v events)", snapshot.Id, len(events))
+	return nil
+}
+
+// DeleteNode removes a node from the network
+// the node's connections are dropped and the node is stopped if it is up
+func (self *Network) DeleteNode(id *adapters.NodeId) error {
+	node := self.GetNode(id)
+	if node == nil {
+		return fmt.Errorf("node %v does not exist", id)
+	}
+	for _, conn := range self.GetConns() {
+		if conn.one != node && conn.other != node {
+			continue
+		}
+		if conn.Up {
+			if err := self.Disconnect(conn.One, conn.Other, true); err != nil {
+				return err
+			}
+		}
+	}
+	if node.Up {
+		if err := self.Stop(id); err != nil {
+			return err
+		}
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	var nodes []*Node
+	self.nodeMap = make(map[discover.NodeID]int)
+	for _, n := range self.Nodes {
+		if n == node {
+			continue
+		}
+		self.nodeMap[n.Id.NodeID] = len(nodes)
+		nodes = append(nodes, n)
+	}
+	self.Nodes = nodes
+	var conns []*Conn
+	self.connMap = make(map[string]int)
+	for _, c := range self.Conns {
+		if c.one == node || c.other == node {
+			continue
+		}
+		self.connMap[ConnLabel(c.One, c.Other)] = len(conns)
+		conns = append(conns, c)
+	}
+	self.Conns = conns
+	glog.V(6).Infof("node %v deleted", id)
+	return nil
+}
+
+// GetNodes returns a copy of the list of nodes of the network
+func (self *Network) GetNodes() []*Node {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	nodes := make([]*Node, len(self.Nodes))
+	copy(nodes, self.Nodes)
+	return nodes
+}
+
+// GetConns returns a copy of the list of connections of the network
+func (self *Network) GetConns() []*Conn {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	conns := make([]*Conn, len(self.Conns))
+	copy(conns, self.Conns)
+	return conns
+}
+
 // newConn adds a new connection to the network
 // it errors if the respective nodes do not exist
 func (self *Network) newConn(oneId, otherId *adapters.NodeId) (*Conn, error) {
@@ -305,8 +671,10 @@ func (self *Network) Start(id *adapters.NodeId) error {
 			return err
 		}
 	}
+	self.lock.Lock()
 	node.Up = true
-	glog.V(6).Infof("started node %v: %v", id, node.Up)
+	self.lock.Unlock()
+	glog.V(6).Infof("started node %v", id)
 
 	self.events.Post(&NodeEvent{
 		Action: "up",
@@ -332,7 +700,9 @@ func (self *Network) Stop(id *adapters.NodeId) error {
 			return err
 		}
 	}
+	self.lock.Lock()
 	node.Up = false
+	self.lock.Unlock()
 	self.events.Post(&NodeEvent{
 		Action: "down",
 		Type:   "node",
@@ -344,7 +714,11 @@ func (self *Network) Stop(id *adapters.NodeId) error {
 // Connect(i, j) attempts to connect nodes i and j (args given as nodeId)
 // calling the node's nodadapters Connect method
 // connection is established (as if) the first node dials out to the other
+// it errors if the nodes are on different sides of a partition (see Partition)
 func (self *Network) Connect(oneId, otherId *adapters.NodeId) error {
+	if self.Blocked(oneId, otherId) {
+		return fmt.Errorf("%v and %v are partitioned", oneId, otherId)
+	}
 	conn, err := self.GetOrCreateConn(oneId, otherId)
 	if err != nil {
 		return err
@@ -365,10 +739,11 @@ func (self *Network) Connect(oneId, otherId *adapters.NodeId) error {
 	// any other way of connection (like peerpool) will need to call back
 	// to this method with connect = false to avoid infinite recursion
 	// this is not relevant for nodes starting up (which can only be externally triggered)
+	// the node is given the address of the peer as known to the adapter
 	if rev {
-		err = conn.other.na.Connect(oneId.Bytes())
+		err = conn.other.na.Connect(conn.one.na.LocalAddr())
 	} else {
-		err = conn.one.na.Connect(otherId.Bytes())
+		err = conn.one.na.Connect(conn.other.na.LocalAddr())
 	}
 	if err != nil {
 		return err
@@ -399,9 +774,9 @@ func (self *Network) Disconnect(oneId, otherId *adapters.NodeId, disconnect bool
 	if disconnect {
 		var err error
 		if rev {
-			err = conn.other.na.Disconnect(oneId.Bytes())
+			err = conn.other.na.Disconnect(conn.one.na.LocalAddr())
 		} else {
-			err = conn.one.na.Disconnect(otherId.Bytes())
+			err = conn.one.na.Disconnect(conn.other.na.LocalAddr())
 		}
 		if err != nil {
 			return err
@@ -411,32 +786,121 @@ func (self *Network) Disconnect(oneId, otherId *adapters.NodeId, disconnect bool
 	// return self.DidDisconnect(oneId, otherId)
 }
 
+// DidConnect is called by the adapters when the connection between
+// nodes one and other is established (one dialled the other)
+// the connection is added to the network if it was not triggered by Connect
+// the state of the connection is checked and changed under the lock, so that
+// of concurrent reports only the first one counts, the event is posted after
+// unlocking as subscribers may call back into the network
 func (self *Network) DidConnect(one, other *adapters.NodeId) error {
-	conn := self.GetConn(one, other)
-	if conn == nil {
-		return fmt.Errorf("connection between %v and %v does not exist", one, other)
+	ev, err := self.didConnect(one, other)
+	if err != nil {
+		return err
+	}
+	// connection event posted
+	self.events.Post(ev)
+	return nil
+}
+
+func (self *Network) didConnect(one, other *adapters.NodeId) (*ConnEvent, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	conn, err := self.getOrCreateConn(one, other)
+	if err != nil {
+		return nil, err
 	}
 	if conn.Up {
-		return fmt.Errorf("%v and %v already connected", one, other)
+		return nil, fmt.Errorf("%v and %v already connected", one, other)
+	}
+	if self.blocked(one, other) {
+		return nil, fmt.Errorf("%v and %v are partitioned", one, other)
 	}
 	conn.Reverse = conn.One.NodeID != one.NodeID
 	conn.Up = true
-	// connection event posted
-	self.events.Post(conn.event(true, conn.Reverse))
-	return nil
+	return conn.event(true, conn.Reverse), nil
 }
 
+// DidDisconnect is called by the adapters when the connection between nodes
+// one and other is dropped (by either of them, see DidConnect)
 func (self *Network) DidDisconnect(one, other *adapters.NodeId) error {
-	conn := self.GetConn(one, other)
+	ev, err := self.didDisconnect(one, other)
+	if err != nil {
+		return err
+	}
+	self.events.Post(ev)
+	return nil
+}
+
+func (self *Network) didDisconnect(one, other *adapters.NodeId) (*ConnEvent, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	conn := self.getConn(one, other)
 	if conn == nil {
-		return fmt.Errorf("connection between %v and %v does not exist", one, other)
+		return nil, fmt.Errorf("connection between %v and %v does not exist", one, other)
 	}
 	if !conn.Up {
-		return fmt.Errorf("%v and %v already disconnected", one, other)
+		return nil, fmt.Errorf("%v and %v already disconnected", one, other)
 	}
 	conn.Reverse = conn.One.NodeID != one.NodeID
 	conn.Up = false
-	self.events.Post(conn.event(false, conn.Reverse))
+	return conn.event(false, conn.Reverse), nil
+}
+
+// Report updates the connection of the nodes reported by a node outside the
+// simulation (see adapters.RemoteReporter) like DidConnect and DidDisconnect,
+// the connection event carries the time of the report
+func (self *Network) Report(report *adapters.ConnReport) error {
+	var ev *ConnEvent
+	var err error
+	if report.Up {
+		ev, err = self.didConnect(report.Caller, report.Callee)
+	} else {
+		ev, err = self.didDisconnect(report.Caller, report.Callee)
+	}
+	if err != nil {
+		return err
+	}
+	ev.Reported = report.Time
+	self.events.Post(ev)
+	return nil
+}
+
+// Connected returns true if the connection between nodes one and other is up
+func (self *Network) Connected(one, other *adapters.NodeId) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	conn := self.getConn(one, other)
+	return conn != nil && conn.Up
+}
+
+// Link returns the profile of the link between nodes one and other
+// (implements adapters.LinkNetwork). Unless set on the connection (see SetLink)
+// the link profile of the dialling node's config applies
+// returns nil (perfect link) if none of them is set
+func (self *Network) Link(one, other *adapters.NodeId) *adapters.LinkProfile {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	conn := self.getConn(one, other)
+	if conn != nil && conn.Link != nil {
+		return conn.Link
+	}
+	node := self.getNode(one)
+	if node == nil || node.config == nil {
+		return nil
+	}
+	return node.config.Link
+}
+
+// SetLink sets the profile of the link between nodes one and other
+// it takes effect when the nodes are next connected
+func (self *Network) SetLink(one, other *adapters.NodeId, link *adapters.LinkProfile) error {
+	conn, err := self.GetOrCreateConn(one, other)
+	if err != nil {
+		return err
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	conn.Link = link
 	return nil
 }
 
@@ -483,6 +947,10 @@ func (self *Network) GetConn(oneId, otherId *adapters.NodeId) *Conn {
 func (self *Network) GetOrCreateConn(oneId, otherId *adapters.NodeId) (*Conn, error) {
 	self.lock.Lock()
 	defer self.lock.Unlock()
+	return self.getOrCreateConn(oneId, otherId)
+}
+
+func (self *Network) getOrCreateConn(oneId, otherId *adapters.NodeId) (*Conn, error) {
 	conn := self.getConn(oneId, otherId)
 	if conn != nil {
 		return conn, nil
@@ -0,0 +1,323 @@
+package simulations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/adapters"
+)
+
+// pingPong sends a ping (code 0) and answers pings with pongs (code 1), the
+// pongs are sent without blocking the read loop as both ends of a pipe may
+// send at once
+func pingPong(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+	errc := make(chan error, 2)
+	go func() {
+		errc <- p2p.Send(rw, 0, "ping")
+	}()
+	for {
+		msg, err := rw.ReadMsg()
+		if err != nil {
+			return err
+		}
+		msg.Discard()
+		if msg.Code == 0 {
+			go func() {
+				errc <- p2p.Send(rw, 1, "pong")
+			}()
+		}
+		select {
+		case err := <-errc:
+			if err != nil {
+				return err
+			}
+		default:
+		}
+	}
+}
+
+func TestMsgTrace(t *testing.T) {
+	eventer := &event.TypeMux{}
+	journal := NewJournal()
+	journal.Subscribe(eventer, MsgEvents...)
+	net := NewNetwork(nil, eventer)
+	names := []string{"ping", "pong"}
+	net.SetNaf(func(conf *NodeConfig) adapters.NodeAdapter {
+		node := adapters.NewSimNode(conf.Id, net, &adapters.SimPipe{})
+		node.Run = pingPong
+		node.Trace(eventer, func(code uint64) string { return names[code] })
+		return node
+	})
+	ids := testIDs()
+	for _, id := range ids {
+		if err := net.NewNode(&NodeConfig{Id: id}); err != nil {
+			t.Fatalf("unexpected error creating node: %v", err)
+		}
+		if err := net.Start(id); err != nil {
+			t.Fatalf("unexpected error starting node: %v", err)
+		}
+	}
+	if err := net.Connect(ids[0], ids[1]); err != nil {
+		t.Fatalf("unexpected error connecting nodes: %v", err)
+	}
+	// a ping and a pong in each direction
+	journal.WaitEntries(4)
+	counts := make(map[string]int)
+	journal.Read(func(ev *event.Event) bool {
+		msg := ev.Data.(*adapters.MsgEvent)
+		if msg.Name != names[msg.Code] {
+			t.Fatalf("incorrect message name: expected %v, got %v", names[msg.Code], msg.Name)
+		}
+		counts[msg.From.Label()+msg.Name+msg.To.Label()]++
+		return true
+	})
+	for _, key := range []string{
+		ids[0].Label() + "ping" + ids[1].Label(),
+		ids[1].Label() + "ping" + ids[0].Label(),
+		ids[0].Label() + "pong" + ids[1].Label(),
+		ids[1].Label() + "pong" + ids[0].Label(),
+	} {
+		if counts[key] != 1 {
+			t.Fatalf("expected one message %v, got %v (%v)", key, counts[key], counts)
+		}
+	}
+}
+
+func TestMsgTraceLostMsgs(t *testing.T) {
+	eventer := &event.TypeMux{}
+	journal := NewJournal()
+	journal.Subscribe(eventer, MsgEvents...)
+	net := NewNetwork(nil, eventer)
+	net.SetNaf(func(conf *NodeConfig) adapters.NodeAdapter {
+		node := adapters.NewSimNode(conf.Id, net, &adapters.SimPipe{})
+		node.Run = pingPong
+		node.Trace(eventer, nil)
+		return node
+	})
+	ids := testIDs()
+	for _, id := range ids {
+		if err := net.NewNode(&NodeConfig{Id: id, Link: &adapters.LinkProfile{Loss: 1}}); err != nil {
+			t.Fatalf("unexpected error creating node: %v", err)
+		}
+		if err := net.Start(id); err != nil {
+			t.Fatalf("unexpected error starting node: %v", err)
+		}
+	}
+	if err := net.Connect(ids[0], ids[1]); err != nil {
+		t.Fatalf("unexpected error connecting nodes: %v", err)
+	}
+	// both pings are lost on the link
+	time.Sleep(100 * time.Millisecond)
+	if n := journal.NewEntries(); n != 0 {
+		t.Fatalf("expected lost messages not to be traced, got %v events", n)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	eventer := &event.TypeMux{}
+	journal := NewJournal()
+	journal.Subscribe(eventer, &PartitionEvent{})
+	net := NewNetwork(nil, eventer)
+	net.SetNaf(func(conf *NodeConfig) adapters.NodeAdapter {
+		return adapters.NewSimNode(conf.Id, net, &adapters.SimPipe{})
+	})
+	ids := append(testIDs(), RandomNodeId())
+	for _, id := range ids {
+		if err := net.NewNode(&NodeConfig{Id: id}); err != nil {
+			t.Fatalf("unexpected error creating node: %v", err)
+		}
+		if err := net.Start(id); err != nil {
+			t.Fatalf("unexpected error starting node: %v", err)
+		}
+	}
+	for _, pair := range [][2]int{{0, 1}, {1, 2}} {
+		if err := net.Connect(ids[pair[0]], ids[pair[1]]); err != nil {
+			t.Fatalf("unexpected error connecting nodes: %v", err)
+		}
+	}
+	if err := net.Partition(ids[:1], ids[1:]); err != nil {
+		t.Fatalf("unexpected error partitioning network: %v", err)
+	}
+	if net.GetConn(ids[0], ids[1]).Up {
+		t.Fatalf("expected connection across the partition to be dropped")
+	}
+	if !net.GetConn(ids[1], ids[2]).Up {
+		t.Fatalf("expected connection within a group to be kept")
+	}
+	if err := net.Connect(ids[2], ids[0]); err == nil {
+		t.Fatalf("expected connection across the partition to be refused")
+	}
+	net.Heal()
+	if err := net.Connect(ids[2], ids[0]); err != nil {
+		t.Fatalf("unexpected error connecting healed nodes: %v", err)
+	}
+	journal.WaitEntries(2)
+	var actions []string
+	journal.Read(func(ev *event.Event) bool {
+		actions = append(actions, ev.Data.(*PartitionEvent).Action)
+		return true
+	})
+	if len(actions) != 2 || actions[0] != "partition" || actions[1] != "heal" {
+		t.Fatalf("expected partition and heal events, got %v", actions)
+	}
+}
+
+func TestVirtualTime(t *testing.T) {
+	eventer := &event.TypeMux{}
+	net := NewNetwork(nil, eventer)
+	clock := &mclock.Simulated{}
+	net.SetClock(clock)
+	journal := NewJournal()
+	journal.Subscribe(eventer, MsgEvents...)
+	net.SetNaf(func(conf *NodeConfig) adapters.NodeAdapter {
+		node := adapters.NewSimNode(conf.Id, net, &adapters.SimPipe{})
+		node.Run = pingPong
+		node.Trace(eventer, nil)
+		return node
+	})
+	// links with one hour latency
+	link := &adapters.LinkProfile{Latency: time.Hour}
+	ids := testIDs()
+	for _, id := range ids {
+		if err := net.NewNode(&NodeConfig{Id: id, Link: link}); err != nil {
+			t.Fatalf("unexpected error creating node: %v", err)
+		}
+		if err := net.Start(id); err != nil {
+			t.Fatalf("unexpected error starting node: %v", err)
+		}
+	}
+	if err := net.Connect(ids[0], ids[1]); err != nil {
+		t.Fatalf("unexpected error connecting nodes: %v", err)
+	}
+	// pings in both directions are sent at time zero and traced when they are
+	// delivered after an hour, the pongs answering them after another hour
+	clock.WaitForTimers(2)
+	clock.Run(time.Hour)
+	journal.WaitEntries(2)
+	clock.WaitForTimers(2)
+	clock.Run(time.Hour)
+	journal.WaitEntries(4)
+	journal.Read(func(ev *event.Event) bool {
+		msg := ev.Data.(*adapters.MsgEvent)
+		expected := time.Duration(msg.Code+1) * time.Hour
+		if delivered := ev.Time.Sub(time.Time{}); delivered != expected {
+			t.Fatalf("expected message %v to be delivered at %v, got %v", msg.Code, expected, delivered)
+		}
+		return true
+	})
+}
+
+func TestRLPxNetwork(t *testing.T) {
+	net := NewNetwork(nil, &event.TypeMux{})
+	net.SetNaf(func(conf *NodeConfig) adapters.NodeAdapter {
+		srv := &p2p.Server{Config: p2p.Config{PrivateKey: conf.PrivateKey}}
+		return adapters.NewReportingRLPx(nil, srv, nil, net)
+	})
+	var ids []*adapters.NodeId
+	for i := 0; i < 2; i++ {
+		conf := RandomNodeConfig()
+		if err := net.NewNode(conf); err != nil {
+			t.Fatalf("unexpected error creating node: %v", err)
+		}
+		if err := net.Start(conf.Id); err != nil {
+			t.Fatalf("unexpected error starting node: %v", err)
+		}
+		defer net.Stop(conf.Id)
+		ids = append(ids, conf.Id)
+	}
+	if err := net.Connect(ids[0], ids[1]); err != nil {
+		t.Fatalf("unexpected error connecting nodes: %v", err)
+	}
+	timeout := time.After(5 * time.Second)
+	for !net.Connected(ids[0], ids[1]) {
+		select {
+		case <-timeout:
+			t.Fatalf("timed out waiting for the connection")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestDidDisconnectConcurrent(t *testing.T) {
+	eventer := &event.TypeMux{}
+	journal := NewJournal()
+	journal.Subscribe(eventer, ConnectivityEvents...)
+	net := NewNetwork(nil, eventer)
+	net.SetNaf(func(conf *NodeConfig) adapters.NodeAdapter {
+		return adapters.NewSimNode(conf.Id, net, &adapters.SimPipe{})
+	})
+	ids := testIDs()
+	for _, id := range ids {
+		if err := net.NewNode(&NodeConfig{Id: id}); err != nil {
+			t.Fatalf("unexpected error creating node: %v", err)
+		}
+	}
+	if err := net.DidConnect(ids[0], ids[1]); err != nil {
+		t.Fatalf("unexpected error reporting connection: %v", err)
+	}
+	// both sides report the disconnect at the same time
+	errc := make(chan error, 2)
+	go func() { errc <- net.DidDisconnect(ids[0], ids[1]) }()
+	go func() { errc <- net.DidDisconnect(ids[1], ids[0]) }()
+	if err1, err2 := <-errc, <-errc; (err1 == nil) == (err2 == nil) {
+		t.Fatalf("expected exactly one report to succeed, got %v and %v", err1, err2)
+	}
+	journal.WaitEntries(2)
+	var downs int
+	journal.Read(func(ev *event.Event) bool {
+		if conn, ok := ev.Data.(*ConnEvent); ok && conn.Action == "down" {
+			downs++
+		}
+		return true
+	})
+	if downs != 1 || net.Connected(ids[0], ids[1]) {
+		t.Fatalf("expected one down event and the connection down, got %v", downs)
+	}
+}
+
+func TestConnEventsCallBack(t *testing.T) {
+	eventer := &event.TypeMux{}
+	net := NewNetwork(nil, eventer)
+	net.SetNaf(func(conf *NodeConfig) adapters.NodeAdapter {
+		return adapters.NewSimNode(conf.Id, net, &adapters.SimPipe{})
+	})
+	ids := testIDs()
+	for _, id := range ids {
+		if err := net.NewNode(&NodeConfig{Id: id}); err != nil {
+			t.Fatalf("unexpected error creating node: %v", err)
+		}
+	}
+	// a subscriber calling back into the network on each event
+	sub := eventer.Subscribe(ConnectivityEvents...)
+	defer sub.Unsubscribe()
+	go func() {
+		for range sub.Chan() {
+			net.Connected(ids[0], ids[1])
+		}
+	}()
+	donec := make(chan error, 1)
+	go func() {
+		for i := 0; i < 100; i++ {
+			if err := net.DidConnect(ids[0], ids[1]); err != nil {
+				donec <- err
+				return
+			}
+			if err := net.DidDisconnect(ids[1], ids[0]); err != nil {
+				donec <- err
+				return
+			}
+		}
+		donec <- nil
+	}()
+	select {
+	case err := <-donec:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out reporting connections")
+	}
+}
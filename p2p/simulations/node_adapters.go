@@ -0,0 +1,157 @@
+package simulations
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/adapters"
+)
+
+// NodeAdapterFactory returns the node adapter function of a network created
+// from the config, the adapter specific config is given as AdapterConfig
+// nodes run the protocol selected by the config (see adapters.RegisterProtocol)
+// the node adapter function returns nil if it cannot create the adapter of a node
+type NodeAdapterFactory func(net *Network, conf *NetworkConfig) (func(*NodeConfig) adapters.NodeAdapter, error)
+
+var (
+	nodeAdaptersLock sync.RWMutex
+	nodeAdapters     = map[string]NodeAdapterFactory{
+		"sim":  simAdapter,
+		"rlpx": rlpxAdapter,
+		"exec": execAdapter,
+	}
+)
+
+// RegisterNodeAdapter makes a node adapter factory available under the given name
+func RegisterNodeAdapter(name string, f NodeAdapterFactory) {
+	nodeAdaptersLock.Lock()
+	defer nodeAdaptersLock.Unlock()
+	nodeAdapters[name] = f
+}
+
+// UnregisterNodeAdapter removes the node adapter factory registered under the given name
+func UnregisterNodeAdapter(name string) {
+	nodeAdaptersLock.Lock()
+	defer nodeAdaptersLock.Unlock()
+	delete(nodeAdapters, name)
+}
+
+// nodeAdapter returns the node adapter function for the network config
+func nodeAdapter(net *Network, conf *NetworkConfig) (func(*NodeConfig) adapters.NodeAdapter, error) {
+	name := conf.Adapter
+	if len(name) == 0 {
+		name = "sim"
+	}
+	nodeAdaptersLock.RLock()
+	f, ok := nodeAdapters[name]
+	nodeAdaptersLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown node adapter '%v'", name)
+	}
+	if len(conf.Protocol) > 0 && !adapters.HasProtocol(conf.Protocol) {
+		return nil, fmt.Errorf("unknown protocol '%v'", conf.Protocol)
+	}
+	return f(net, conf)
+}
+
+// decodeAdapterConfig decodes the adapter specific config if given
+func decodeAdapterConfig(conf *NetworkConfig, v interface{}) error {
+	if len(conf.AdapterConfig) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(conf.AdapterConfig, v); err != nil {
+		return fmt.Errorf("invalid %v adapter config: %v", conf.Adapter, err)
+	}
+	return nil
+}
+
+// nodeProtocols creates the protocols of a node, errors are logged
+func nodeProtocols(conf *NetworkConfig, id *adapters.NodeId) ([]p2p.Protocol, bool) {
+	protocols, err := adapters.NewProtocols(conf.Protocol, id, conf.ProtocolConfig)
+	if err != nil {
+		glog.V(6).Infof("cannot create protocols of node %v: %v", id, err)
+		return nil, false
+	}
+	return protocols, true
+}
+
+// simAdapter runs nodes in memory (see adapters.SimNode), sim nodes run at most
+// one protocol, messages are traced if the network config says so and named
+// if the protocol registered names (see adapters.RegisterMsgNames)
+func simAdapter(net *Network, conf *NetworkConfig) (func(*NodeConfig) adapters.NodeAdapter, error) {
+	return func(nc *NodeConfig) adapters.NodeAdapter {
+		protocols, ok := nodeProtocols(conf, nc.Id)
+		if !ok {
+			return nil
+		}
+		if len(protocols) > 1 {
+			glog.V(6).Infof("sim node %v can only run one protocol", nc.Id)
+			return nil
+		}
+		node := adapters.NewSimNode(nc.Id, net, &adapters.SimPipe{})
+		if len(protocols) == 1 {
+			node.Run = protocols[0].Run
+		}
+		if conf.Trace {
+			node.Trace(net.Events(), adapters.MsgNames(conf.Protocol))
+		}
+		return node
+	}, nil
+}
+
+// RLPxAdapterConfig configures the p2p.Servers of nodes run by the rlpx adapter
+type RLPxAdapterConfig struct {
+	ListenAddr string
+	MaxPeers   int
+}
+
+// rlpxAdapter runs nodes as p2p.Servers in the same process connected over TCP
+// (see adapters.RLPx), nodes need a private key
+func rlpxAdapter(net *Network, conf *NetworkConfig) (func(*NodeConfig) adapters.NodeAdapter, error) {
+	rc := &RLPxAdapterConfig{}
+	if err := decodeAdapterConfig(conf, rc); err != nil {
+		return nil, err
+	}
+	return func(nc *NodeConfig) adapters.NodeAdapter {
+		if nc.PrivateKey == nil {
+			glog.V(6).Infof("rlpx node %v has no private key", nc.Id)
+			return nil
+		}
+		protocols, ok := nodeProtocols(conf, nc.Id)
+		if !ok {
+			return nil
+		}
+		srv := &p2p.Server{
+			Config: p2p.Config{
+				PrivateKey: nc.PrivateKey,
+				ListenAddr: rc.ListenAddr,
+				MaxPeers:   rc.MaxPeers,
+				Protocols:  protocols,
+				Name:       adapters.Name(nc.Id.Bytes()),
+			},
+		}
+		return adapters.NewReportingRLPx(nil, srv, nil, net)
+	}, nil
+}
+
+// execAdapter runs nodes as separate processes (see adapters.ExecNode), the
+// adapter config is an adapters.ExecConfig, the protocol is created in the
+// node process so it must be registered there too
+func execAdapter(net *Network, conf *NetworkConfig) (func(*NodeConfig) adapters.NodeAdapter, error) {
+	ec := &adapters.ExecConfig{}
+	if err := decodeAdapterConfig(conf, ec); err != nil {
+		return nil, err
+	}
+	ec.Protocol = conf.Protocol
+	ec.ProtocolConfig = conf.ProtocolConfig
+	return func(nc *NodeConfig) adapters.NodeAdapter {
+		if nc.PrivateKey == nil {
+			glog.V(6).Infof("exec node %v has no private key", nc.Id)
+			return nil
+		}
+		return adapters.NewExecNode(nc.Id, nc.PrivateKey, net, ec)
+	}, nil
+}
@@ -0,0 +1,133 @@
+package simulations
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/adapters"
+)
+
+func init() {
+	adapters.RegisterProtocol("pingpong", func(id *adapters.NodeId, conf json.RawMessage) ([]p2p.Protocol, error) {
+		return []p2p.Protocol{{Name: "pingpong", Version: 1, Length: 2, Run: pingPong}}, nil
+	})
+	adapters.RegisterMsgNames("pingpong", func(code uint64) string {
+		return []string{"ping", "pong"}[code]
+	})
+}
+
+func testNetwork(t *testing.T, conf *NetworkConfig) (*NetworkController, []*adapters.NodeId) {
+	nc, err := newNetwork(conf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var ids []*adapters.NodeId
+	for i := 0; i < 2; i++ {
+		node := RandomNodeConfig()
+		if err := nc.net.NewNode(node); err != nil {
+			t.Fatalf("unexpected error creating node: %v", err)
+		}
+		if err := nc.net.Start(node.Id); err != nil {
+			t.Fatalf("unexpected error starting node: %v", err)
+		}
+		ids = append(ids, node.Id)
+	}
+	if err := nc.net.Connect(ids[0], ids[1]); err != nil {
+		t.Fatalf("unexpected error connecting nodes: %v", err)
+	}
+	return nc, ids
+}
+
+func TestSimAdapter(t *testing.T) {
+	nc, _ := testNetwork(t, &NetworkConfig{Adapter: "sim", Protocol: "pingpong", Trace: true})
+	defer nc.Shutdown()
+	timeout := time.After(5 * time.Second)
+	for {
+		result, _ := nc.journal.Query(&QueryConfig{Types: []string{"msg"}})
+		// a ping and a pong in each direction, named by the protocol
+		if result.Total == 4 {
+			for _, ev := range result.Events {
+				if msg := ev.Data.(*adapters.MsgEvent); msg.Name != []string{"ping", "pong"}[msg.Code] {
+					t.Fatalf("unexpected name of message %v: %v", msg.Code, msg.Name)
+				}
+			}
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("timed out waiting for messages, got %v", result.Total)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestRLPxAdapter(t *testing.T) {
+	nc, ids := testNetwork(t, &NetworkConfig{
+		Adapter:       "rlpx",
+		AdapterConfig: json.RawMessage(`{"MaxPeers": 10}`),
+		Protocol:      "pingpong",
+	})
+	defer nc.Shutdown()
+	timeout := time.After(5 * time.Second)
+	for !nc.net.Connected(ids[0], ids[1]) {
+		select {
+		case <-timeout:
+			t.Fatalf("timed out waiting for the connection")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if err := nc.net.NewNode(&NodeConfig{Id: RandomNodeId()}); err == nil {
+		t.Fatalf("expected error creating rlpx node without private key")
+	}
+}
+
+func TestNodeAdapterConfig(t *testing.T) {
+	for _, conf := range []*NetworkConfig{
+		{Adapter: "unknown"},
+		{Protocol: "unknown"},
+		{Adapter: "rlpx", AdapterConfig: json.RawMessage(`{"MaxPeers": "many"}`)},
+	} {
+		if _, err := newNetwork(conf); err == nil {
+			t.Fatalf("expected error for config %+v", conf)
+		}
+	}
+}
+
+func TestRegisterConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("concurrent%d", i)
+			RegisterNodeAdapter(name, simAdapter)
+			defer UnregisterNodeAdapter(name)
+			adapters.RegisterProtocol(name, func(id *adapters.NodeId, conf json.RawMessage) ([]p2p.Protocol, error) {
+				return nil, nil
+			})
+			defer adapters.UnregisterProtocol(name)
+			for _, conf := range []*NetworkConfig{{Adapter: name, Protocol: "pingpong"}, {Protocol: name}} {
+				nc, err := newNetwork(conf)
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+					continue
+				}
+				nc.Shutdown()
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < 8; i++ {
+		name := fmt.Sprintf("concurrent%d", i)
+		if _, err := newNetwork(&NetworkConfig{Adapter: name}); err == nil {
+			t.Fatalf("expected node adapter %v to be unregistered", name)
+		}
+		if adapters.HasProtocol(name) {
+			t.Fatalf("expected protocol %v to be unregistered", name)
+		}
+	}
+}
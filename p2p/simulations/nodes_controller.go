@@ -0,0 +1,201 @@
+package simulations
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/p2p/adapters"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+// NodeControl is the body of PUT requests on a node resource
+// it sets the desired state of the node (started or stopped)
+type NodeControl struct {
+	Up bool `json:"up"`
+}
+
+// NewNodesController creates a ResourceController for the nodes of the network
+// POST creates a new node (with a random id and key unless given in the NodeConfig),
+// GET lists all the nodes
+// individual nodes are available as /<networkId>/nodes/<nodeId> where nodeId
+// is the hex encoded node id
+func NewNodesController(net *Network) Controller {
+	self := NewResourceContoller(
+		&ResourceHandlers{
+			// POST /<networkId>/nodes
+			Create: &ResourceHandler{
+				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
+					conf := msg.(*NodeConfig)
+					if conf.Id == nil {
+						random := RandomNodeConfig()
+						conf.Id, conf.PrivateKey = random.Id, random.PrivateKey
+					}
+					if err := net.NewNode(conf); err != nil {
+						return nil, err
+					}
+					return net.GetNode(conf.Id), nil
+				},
+				Type: reflect.TypeOf(&NodeConfig{}),
+			},
+			// GET /<networkId>/nodes
+			Retrieve: &ResourceHandler{
+				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
+					return net.GetNodes(), nil
+				},
+			},
+		})
+	self.SetResourceLookup(func(id string) (Controller, error) {
+		node, err := lookupNode(net, id)
+		if err != nil {
+			return nil, err
+		}
+		return NewNodeController(net, node.Id), nil
+	})
+	return self
+}
+
+// NewNodeController creates a ResourceController for a node of the network
+// GET returns the node, PUT starts or stops it (see NodeControl) and
+// DELETE removes it from the network
+// connections of the node are available as /<networkId>/nodes/<nodeId>/conns/<peerId>
+func NewNodeController(net *Network, id *adapters.NodeId) Controller {
+	self := NewResourceContoller(
+		&ResourceHandlers{
+			// GET /<networkId>/nodes/<nodeId>
+			Retrieve: &ResourceHandler{
+				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
+					return net.GetNode(id), nil
+				},
+			},
+			// PUT /<networkId>/nodes/<nodeId>
+			Update: &ResourceHandler{
+				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
+					var err error
+					if msg.(*NodeControl).Up {
+						err = net.Start(id)
+					} else {
+						err = net.Stop(id)
+					}
+					if err != nil {
+						return nil, err
+					}
+					return net.GetNode(id), nil
+				},
+				Type: reflect.TypeOf(&NodeControl{}),
+			},
+			// DELETE /<networkId>/nodes/<nodeId>
+			Destroy: &ResourceHandler{
+				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
+					return empty, net.DeleteNode(id)
+				},
+			},
+		})
+	self.SetResource("conns", NewConnsController(net, id))
+	return self
+}
+
+// NewConnsController creates a ResourceController for the connections of a node
+// GET lists the connections the node is part of
+func NewConnsController(net *Network, id *adapters.NodeId) Controller {
+	self := NewResourceContoller(
+		&ResourceHandlers{
+			// GET /<networkId>/nodes/<nodeId>/conns
+			Retrieve: &ResourceHandler{
+				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
+					conns := []*Conn{}
+					for _, conn := range net.GetConns() {
+						if conn.One.NodeID == id.NodeID || conn.Other.NodeID == id.NodeID {
+							conns = append(conns, conn)
+						}
+					}
+					return conns, nil
+				},
+			},
+		})
+	self.SetResourceLookup(func(peerId string) (Controller, error) {
+		peer, err := lookupNode(net, peerId)
+		if err != nil {
+			return nil, err
+		}
+		return NewConnController(net, id, peer.Id), nil
+	})
+	return self
+}
+
+// NewConnController creates a ResourceController for the connection between
+// two nodes, PUT connects the node to the peer (node dials out) optionally
+// setting the profile of the link if given as body (see adapters.LinkProfile),
+// DELETE disconnects them (node drops the peer), GET returns the connection
+func NewConnController(net *Network, one, other *adapters.NodeId) Controller {
+	return NewResourceContoller(
+		&ResourceHandlers{
+			// GET /<networkId>/nodes/<nodeId>/conns/<peerId>
+			Retrieve: &ResourceHandler{
+				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
+					conn := net.GetConn(one, other)
+					if conn == nil {
+						return nil, fmt.Errorf("connection between %v and %v does not exist", one, other)
+					}
+					return conn, nil
+				},
+			},
+			// PUT /<networkId>/nodes/<nodeId>/conns/<peerId>
+			Update: &ResourceHandler{
+				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
+					if link := msg.(*adapters.LinkProfile); link != nil && *link != (adapters.LinkProfile{}) {
+						if err := net.SetLink(one, other, link); err != nil {
+							return nil, err
+						}
+					}
+					if err := net.Connect(one, other); err != nil {
+						return nil, err
+					}
+					return net.GetConn(one, other), nil
+				},
+				Type: reflect.TypeOf(&adapters.LinkProfile{}),
+			},
+			// DELETE /<networkId>/nodes/<nodeId>/conns/<peerId>
+			Destroy: &ResourceHandler{
+				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
+					return empty, net.Disconnect(one, other, true)
+				},
+			},
+		})
+}
+
+func lookupNode(net *Network, id string) (*Node, error) {
+	nodeId, err := discover.HexID(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node id %v: %v", id, err)
+	}
+	node := net.GetNode(&adapters.NodeId{NodeID: nodeId})
+	if node == nil {
+		return nil, fmt.Errorf("node %v does not exist", id)
+	}
+	return node, nil
+}
+
+// NewReportController creates a ResourceController receiving the connection
+// reports of nodes running outside the simulation (see adapters.RemoteReporter)
+// POST updates the network with the adapters.ConnReport given as body
+func NewReportController(net *Network) Controller {
+	return NewResourceContoller(
+		&ResourceHandlers{
+			// POST /<networkId>/report
+			Create: &ResourceHandler{
+				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
+					report := msg.(*adapters.ConnReport)
+					if report.Caller == nil || report.Callee == nil {
+						return nil, fmt.Errorf("missing caller or callee in report")
+					}
+					glog.V(6).Infof("report from %v at %v: %v (up: %v)", report.Caller, report.Time, report.Callee, report.Up)
+					if err := net.Report(report); err != nil {
+						return nil, err
+					}
+					return net.GetConn(report.Caller, report.Callee), nil
+				},
+				Type: reflect.TypeOf(&adapters.ConnReport{}),
+			},
+		})
+}
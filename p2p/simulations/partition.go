@@ -0,0 +1,122 @@
+package simulations
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/p2p/adapters"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+// PartitionEvent is posted when the network is partitioned (action "partition")
+// or healed (action "heal"), groups lists the nodes on each side of the partition
+type PartitionEvent struct {
+	Action string               `json:"action"`
+	Type   string               `json:"type"`
+	Groups [][]*adapters.NodeId `json:"groups,omitempty"`
+}
+
+func (self *PartitionEvent) String() string {
+	return fmt.Sprintf("<Action: %v, Type: %v, Groups: %v>\n", self.Action, self.Type, self.Groups)
+}
+
+// PartitionConfig is the body of PUT requests on the partition resource
+type PartitionConfig struct {
+	Groups [][]*adapters.NodeId `json:"groups"`
+}
+
+// Partition splits the network into the groups of nodes given as args
+// all connections between nodes in different groups are dropped and new ones
+// are refused until the network is healed (see Heal)
+// nodes not listed in any of the groups are not restricted
+// a new partition replaces the one in effect
+func (self *Network) Partition(groups ...[]*adapters.NodeId) error {
+	partition := make(map[discover.NodeID]int)
+	for i, group := range groups {
+		for _, id := range group {
+			if self.GetNode(id) == nil {
+				return fmt.Errorf("node %v does not exist", id)
+			}
+			if j, found := partition[id.NodeID]; found && j != i {
+				return fmt.Errorf("node %v is listed in more than one group", id)
+			}
+			partition[id.NodeID] = i
+		}
+	}
+	self.lock.Lock()
+	self.partition = partition
+	self.lock.Unlock()
+	self.events.Post(&PartitionEvent{
+		Action: "partition",
+		Type:   "partition",
+		Groups: groups,
+	})
+	for _, conn := range self.GetConns() {
+		if !conn.Up || !self.Blocked(conn.One, conn.Other) {
+			continue
+		}
+		if err := self.Disconnect(conn.One, conn.Other, true); err != nil {
+			return fmt.Errorf("cannot disconnect %v: %v", conn, err)
+		}
+	}
+	glog.V(6).Infof("network partitioned into %v groups", len(groups))
+	return nil
+}
+
+// Heal lifts the partition of the network, connections dropped by the
+// partition are not reestablished
+func (self *Network) Heal() {
+	self.lock.Lock()
+	self.partition = nil
+	self.lock.Unlock()
+	self.events.Post(&PartitionEvent{
+		Action: "heal",
+		Type:   "partition",
+	})
+	glog.V(6).Infof("network healed")
+}
+
+// Blocked returns true if the partition of the network in effect does not
+// allow nodes one and other to connect
+func (self *Network) Blocked(one, other *adapters.NodeId) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.blocked(one, other)
+}
+
+func (self *Network) blocked(one, other *adapters.NodeId) bool {
+	i, found := self.partition[one.NodeID]
+	if !found {
+		return false
+	}
+	j, found := self.partition[other.NodeID]
+	return found && i != j
+}
+
+// NewPartitionController creates a ResourceController for the partition of
+// the network, PUT partitions the network into the groups of the PartitionConfig
+// given as body, DELETE heals the network
+func NewPartitionController(net *Network) Controller {
+	return NewResourceContoller(
+		&ResourceHandlers{
+			// PUT /<networkId>/partition
+			Update: &ResourceHandler{
+				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
+					conf := msg.(*PartitionConfig)
+					if err := net.Partition(conf.Groups...); err != nil {
+						return nil, err
+					}
+					return conf, nil
+				},
+				Type: reflect.TypeOf(&PartitionConfig{}),
+			},
+			// DELETE /<networkId>/partition
+			Destroy: &ResourceHandler{
+				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
+					net.Heal()
+					return nil, nil
+				},
+			},
+		})
+}
@@ -0,0 +1,290 @@
+package simulations
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/logger/glog"
+)
+
+// JournalPlayConfig configures a journal player, the journal to replay is
+// either given in full or refers to a journal uploaded by JournalId
+// intervals between events are divided by SpeedUp, with SpeedUp 0 the events
+// are replayed without waiting
+type JournalPlayConfig struct {
+	Id        string
+	SpeedUp   float64
+	Journal   *Journal
+	JournalId string
+	Events    []string
+	Loop      bool
+	Paused    bool
+}
+
+// JournalPlayerStatus reports the progress of a journal player
+// Index is the index of the next event to play, Played counts all events
+// played (including earlier loops), Time is the (simulated) time of the
+// last event played
+type JournalPlayerStatus struct {
+	Id      string
+	Index   int
+	Total   int
+	Played  int
+	Loops   int
+	Time    time.Time
+	SpeedUp float64
+	Paused  bool
+	Loop    bool
+	Done    bool
+}
+
+// JournalPlayerControl changes a running journal player, nil fields are left
+// unchanged, Seek moves to the given event index, SeekTime to the first event
+// not before the given time
+type JournalPlayerControl struct {
+	Paused   *bool
+	SpeedUp  *float64
+	Loop     *bool
+	Seek     *int
+	SeekTime *time.Time
+}
+
+// JournalPlayer replays the events of a journal to an eventer preserving
+// their relative timing on the given clock
+// the player works on a copy of the events, the source journal is not consumed
+type JournalPlayer struct {
+	Id      string
+	events  []*event.Event
+	eventer *event.TypeMux
+	clock   mclock.Clock
+	lock    sync.Mutex
+	index   int
+	played  int
+	loops   int
+	last    time.Time // time of the last event played, zero after a seek
+	speedUp float64
+	paused  bool
+	loop    bool
+	gen     int // incremented by every control so that waits can be abandoned
+	wakec   chan struct{}
+	quitc   chan struct{}
+	once    sync.Once
+}
+
+// NewJournalPlayer creates a player for the events of the journal
+// call Run to start playing
+func NewJournalPlayer(conf *JournalPlayConfig, eventer *event.TypeMux, clock mclock.Clock) *JournalPlayer {
+	if clock == nil {
+		clock = mclock.System{}
+	}
+	events, _ := conf.Journal.History(0)
+	return &JournalPlayer{
+		Id:      conf.Id,
+		events:  events,
+		eventer: eventer,
+		clock:   clock,
+		speedUp: conf.SpeedUp,
+		paused:  conf.Paused,
+		loop:    conf.Loop,
+		wakec:   make(chan struct{}, 1),
+		quitc:   make(chan struct{}),
+	}
+}
+
+// Run plays the events until the player is stopped, once the last event is
+// played the player loops or waits for a seek
+func (self *JournalPlayer) Run() {
+	for {
+		self.lock.Lock()
+		if self.index >= len(self.events) && self.loop && len(self.events) > 0 {
+			self.index = 0
+			self.loops++
+			self.last = time.Time{}
+		}
+		if self.paused || self.index >= len(self.events) {
+			self.lock.Unlock()
+			select {
+			case <-self.wakec:
+				continue
+			case <-self.quitc:
+				return
+			}
+		}
+		gen := self.gen
+		ev := self.events[self.index]
+		var wait time.Duration
+		if !self.last.IsZero() && self.speedUp > 0 {
+			wait = time.Duration(float64(ev.Time.Sub(self.last)) / self.speedUp)
+		}
+		self.lock.Unlock()
+
+		if wait > 0 {
+			select {
+			case <-self.clock.After(wait):
+			case <-self.wakec:
+				// the interval is waited again with the new settings
+				continue
+			case <-self.quitc:
+				return
+			}
+		}
+
+		self.lock.Lock()
+		if gen != self.gen {
+			self.lock.Unlock()
+			continue
+		}
+		self.index++
+		self.played++
+		self.last = ev.Time
+		self.lock.Unlock()
+		self.eventer.Post(ev.Data)
+	}
+}
+
+// Stop terminates Run
+func (self *JournalPlayer) Stop() {
+	self.once.Do(func() { close(self.quitc) })
+}
+
+// Status returns the progress of the player
+func (self *JournalPlayer) Status() *JournalPlayerStatus {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return &JournalPlayerStatus{
+		Id:      self.Id,
+		Index:   self.index,
+		Total:   len(self.events),
+		Played:  self.played,
+		Loops:   self.loops,
+		Time:    self.last,
+		SpeedUp: self.speedUp,
+		Paused:  self.paused,
+		Loop:    self.loop,
+		Done:    self.index >= len(self.events) && !self.loop,
+	}
+}
+
+// Control applies the changes to the player
+func (self *JournalPlayer) Control(c *JournalPlayerControl) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if c.SpeedUp != nil && *c.SpeedUp < 0 {
+		return fmt.Errorf("invalid speed up: %v", *c.SpeedUp)
+	}
+	if c.Seek != nil && (*c.Seek < 0 || *c.Seek > len(self.events)) {
+		return fmt.Errorf("invalid event index: %v (total %v)", *c.Seek, len(self.events))
+	}
+	if c.Paused != nil {
+		self.paused = *c.Paused
+	}
+	if c.SpeedUp != nil {
+		self.speedUp = *c.SpeedUp
+	}
+	if c.Loop != nil {
+		self.loop = *c.Loop
+	}
+	if c.SeekTime != nil {
+		self.seek(len(self.events))
+		for i, ev := range self.events {
+			if !ev.Time.Before(*c.SeekTime) {
+				self.seek(i)
+				break
+			}
+		}
+	}
+	if c.Seek != nil {
+		self.seek(*c.Seek)
+	}
+	self.gen++
+	select {
+	case self.wakec <- struct{}{}:
+	default:
+	}
+	glog.V(6).Infof("player %v at %v/%v (paused: %v, speed up: %v, loop: %v)", self.Id, self.index, len(self.events), self.paused, self.speedUp, self.loop)
+	return nil
+}
+
+func (self *JournalPlayer) seek(i int) {
+	self.index = i
+	self.last = time.Time{}
+}
+
+// NewJournalPlayersController creates a ResourceController for replaying
+// journals, the replay is timed on the clock of the network and players stop
+// when the network is shut down
+func NewJournalPlayersController(eventer *event.TypeMux, journals *JournalController, net *Network) Controller {
+	self := NewResourceContoller(
+		&ResourceHandlers{
+			// POST /<networkId>/journals
+			Create: &ResourceHandler{
+				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
+					conf := msg.(*JournalPlayConfig)
+					if conf.Journal == nil {
+						conf.Journal = journals.Journal(conf.JournalId)
+						if conf.Journal == nil {
+							return nil, fmt.Errorf("journal '%v' not found", conf.JournalId)
+						}
+					}
+					if conf.SpeedUp < 0 {
+						return nil, fmt.Errorf("invalid speed up: %v", conf.SpeedUp)
+					}
+					if len(conf.Id) == 0 {
+						conf.Id = fmt.Sprintf("%d", parent.id)
+					}
+					player := NewJournalPlayer(conf, eventer, net.Clock())
+					go player.Run()
+					go func() {
+						select {
+						case <-net.Done():
+							player.Stop()
+						case <-player.quitc:
+						}
+					}()
+					parent.SetResource(conf.Id, NewJournalPlayerController(player))
+					parent.id++
+					return player.Status(), nil
+				},
+				Type: reflect.TypeOf(&JournalPlayConfig{}),
+			},
+		})
+	return self
+}
+
+// NewJournalPlayerController creates a ResourceController for a running player
+// GET reports its status, PUT controls it (see JournalPlayerControl)
+// and DELETE stops it
+func NewJournalPlayerController(player *JournalPlayer) Controller {
+	self := NewResourceContoller(
+		&ResourceHandlers{
+			// GET /<networkId>/journals/<playerId>
+			Retrieve: &ResourceHandler{
+				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
+					return player.Status(), nil
+				},
+			},
+			// PUT /<networkId>/journals/<playerId>
+			Update: &ResourceHandler{
+				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
+					if err := player.Control(msg.(*JournalPlayerControl)); err != nil {
+						return nil, err
+					}
+					return player.Status(), nil
+				},
+				Type: reflect.TypeOf(&JournalPlayerControl{}),
+			},
+			// DELETE /<networkId>/journals/<playerId>
+			Destroy: &ResourceHandler{
+				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
+					player.Stop()
+					parent.detach()
+					return empty, nil
+				},
+			},
+		})
+	return self
+}
@@ -0,0 +1,99 @@
+package simulations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+func waitStatus(t *testing.T, player *JournalPlayer, f func(*JournalPlayerStatus) bool) *JournalPlayerStatus {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status := player.Status()
+		if f(status) {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for player status, last status: %+v", status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestJournalPlayer(t *testing.T) {
+	j := NewJournal()
+	j.Events = testEvents(1000, 1000, 1000, 1000, 1000)
+	eventer := &event.TypeMux{}
+	sub := eventer.Subscribe(&NodeEvent{})
+	defer sub.Unsubscribe()
+	go func() {
+		for _ = range sub.Chan() {
+		}
+	}()
+	clock := &mclock.Simulated{}
+	player := NewJournalPlayer(&JournalPlayConfig{Id: "0", Journal: j, SpeedUp: 2}, eventer, clock)
+	go player.Run()
+	defer player.Stop()
+
+	// the first event is played at once, the second after half of the interval
+	waitStatus(t, player, func(s *JournalPlayerStatus) bool { return s.Played == 1 })
+	clock.WaitForTimers(1)
+	clock.Run(400 * time.Millisecond)
+	if status := player.Status(); status.Played != 1 {
+		t.Fatalf("expected 1 event played before the interval, got %v", status.Played)
+	}
+	clock.Run(100 * time.Millisecond)
+	status := waitStatus(t, player, func(s *JournalPlayerStatus) bool { return s.Played == 2 })
+	if status.Index != 2 || status.Total != 5 || !status.Time.Equal(j.Events[1].Time) {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	// nothing is played while paused
+	paused, resumed := true, false
+	if err := player.Control(&JournalPlayerControl{Paused: &paused}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clock.Run(10 * time.Second)
+	time.Sleep(10 * time.Millisecond)
+	if status := player.Status(); status.Played != 2 || !status.Paused {
+		t.Fatalf("expected paused player, got %+v", status)
+	}
+
+	// seeking plays the event sought at once
+	seek := 4
+	if err := player.Control(&JournalPlayerControl{Paused: &resumed, Seek: &seek}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	status = waitStatus(t, player, func(s *JournalPlayerStatus) bool { return s.Done })
+	if status.Played != 3 || status.Index != 5 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	// looping restarts from the first event, seeking by time
+	loop := true
+	if err := player.Control(&JournalPlayerControl{Loop: &loop}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitStatus(t, player, func(s *JournalPlayerStatus) bool { return s.Loops == 1 && s.Played == 4 })
+	if err := player.Control(&JournalPlayerControl{Paused: &paused, SeekTime: &j.Events[3].Time}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status := player.Status(); status.Index != 3 {
+		t.Fatalf("expected to seek to event 3, got %v", status.Index)
+	}
+
+	// without speed up the remaining events are played at once
+	speedUp := 0.0
+	loop = false
+	if err := player.Control(&JournalPlayerControl{Paused: &resumed, SpeedUp: &speedUp, Loop: &loop}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitStatus(t, player, func(s *JournalPlayerStatus) bool { return s.Done && s.Played == 6 })
+
+	seek = 6
+	if err := player.Control(&JournalPlayerControl{Seek: &seek}); err == nil {
+		t.Fatalf("expected error for invalid event index")
+	}
+}
@@ -0,0 +1,345 @@
+package simulations
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p/adapters"
+)
+
+// QueryConfig selects events of a journal, empty filters match all events
+// Types are event types ("node", "conn", "partition", "msg"), Nodes match
+// events involving any of the nodes, Actions are "up", "down", "partition"
+// and "heal", the time window includes From and excludes To
+// matching events are paginated by Offset and Limit (no limit if 0)
+// if Aggregates is set the result includes node uptimes, connection
+// lifetimes and degrees over the time window (see Aggregate)
+type QueryConfig struct {
+	Types      []string
+	Nodes      []*adapters.NodeId
+	Actions    []string
+	From       time.Time
+	To         time.Time
+	Offset     int
+	Limit      int
+	Aggregates bool
+}
+
+// QueryResult is a page of the events matching a query, Total is the number
+// of all matching events
+type QueryResult struct {
+	Total      int
+	Offset     int
+	Events     []*event.Event
+	Aggregates *Aggregates `json:",omitempty"`
+}
+
+// Aggregates summarise the connectivity of a journal over a time window
+// nodes and connections up at the end of the window are counted as up to its end
+type Aggregates struct {
+	Nodes   []*NodeUptime
+	Conns   []*ConnLifetime
+	Degrees []*DegreeSeries
+}
+
+// NodeUptime is the total time a node was up and the number of times it came up
+type NodeUptime struct {
+	Id     *adapters.NodeId
+	Uptime time.Duration
+	Ups    int
+}
+
+// ConnLifetime is the total time a connection was up and the number of times
+// it was established
+type ConnLifetime struct {
+	One      *adapters.NodeId
+	Other    *adapters.NodeId
+	Lifetime time.Duration
+	Ups      int
+}
+
+// DegreeSeries lists the changes in the number of connections of a node
+type DegreeSeries struct {
+	Id      *adapters.NodeId
+	Samples []*DegreeSample
+}
+
+type DegreeSample struct {
+	Time   time.Time
+	Degree int
+}
+
+// eventAction returns the action of the event data, empty for messages
+func eventAction(data interface{}) string {
+	switch ev := data.(type) {
+	case *NodeEvent:
+		return ev.Action
+	case *ConnEvent:
+		return ev.Action
+	case *PartitionEvent:
+		return ev.Action
+	}
+	return ""
+}
+
+// eventNodes returns the nodes the event data involves
+func eventNodes(data interface{}) []*adapters.NodeId {
+	switch ev := data.(type) {
+	case *NodeEvent:
+		return []*adapters.NodeId{ev.node.Id}
+	case *ConnEvent:
+		return []*adapters.NodeId{ev.conn.One, ev.conn.Other}
+	case *adapters.MsgEvent:
+		return []*adapters.NodeId{ev.From, ev.To}
+	case *PartitionEvent:
+		var ids []*adapters.NodeId
+		for _, group := range ev.Groups {
+			ids = append(ids, group...)
+		}
+		return ids
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, t := range list {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *QueryConfig) inWindow(t time.Time) bool {
+	if !self.From.IsZero() && t.Before(self.From) {
+		return false
+	}
+	if !self.To.IsZero() && !t.Before(self.To) {
+		return false
+	}
+	return true
+}
+
+// Match tells if the event matches the filters of the query
+func (self *QueryConfig) Match(ev *event.Event) bool {
+	if !self.inWindow(ev.Time) {
+		return false
+	}
+	if len(self.Types) > 0 && !contains(self.Types, eventType(ev.Data)) {
+		return false
+	}
+	if len(self.Actions) > 0 && !contains(self.Actions, eventAction(ev.Data)) {
+		return false
+	}
+	if len(self.Nodes) == 0 {
+		return true
+	}
+	for _, id := range eventNodes(ev.Data) {
+		for _, n := range self.Nodes {
+			if id != nil && id.NodeID == n.NodeID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Query returns the events of the journal matching the query
+// it does not advance the cursor of the journal
+func (self *Journal) Query(conf *QueryConfig) (*QueryResult, error) {
+	if conf.Offset < 0 || conf.Limit < 0 {
+		return nil, fmt.Errorf("invalid page: offset %v, limit %v", conf.Offset, conf.Limit)
+	}
+	events, _ := self.History(0)
+	result := &QueryResult{Offset: conf.Offset}
+	for _, ev := range events {
+		if !conf.Match(ev) {
+			continue
+		}
+		if result.Total >= conf.Offset && (conf.Limit == 0 || len(result.Events) < conf.Limit) {
+			result.Events = append(result.Events, ev)
+		}
+		result.Total++
+	}
+	if conf.Aggregates {
+		result.Aggregates = aggregate(events, conf)
+	}
+	return result, nil
+}
+
+// Aggregate returns the node uptimes, connection lifetimes and degrees over
+// the time window of the query, restricted to the nodes of the query if given
+// (Types, Actions and pagination are ignored), the window ends with the last
+// event of the journal if To is not set
+func (self *Journal) Aggregate(conf *QueryConfig) *Aggregates {
+	events, _ := self.History(0)
+	return aggregate(events, conf)
+}
+
+func aggregate(events []*event.Event, conf *QueryConfig) *Aggregates {
+	selected := func(ids ...*adapters.NodeId) bool {
+		if len(conf.Nodes) == 0 {
+			return true
+		}
+		for _, id := range ids {
+			for _, n := range conf.Nodes {
+				if id.NodeID == n.NodeID {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	end := conf.To
+	if end.IsZero() && len(events) > 0 {
+		end = events[len(events)-1].Time
+	}
+	// clip restricts intervals to the window
+	clip := func(since, until time.Time) time.Duration {
+		if since.Before(conf.From) {
+			since = conf.From
+		}
+		if until.After(end) {
+			until = end
+		}
+		if until.Before(since) {
+			return 0
+		}
+		return until.Sub(since)
+	}
+
+	nodes := make(map[string]*NodeUptime)
+	nodesSince := make(map[string]time.Time)
+	conns := make(map[string]*ConnLifetime)
+	connsSince := make(map[string]time.Time)
+	degrees := make(map[string]*DegreeSeries)
+	degree := make(map[string]int)
+	setDegree := func(id *adapters.NodeId, d int, t time.Time) {
+		label := id.String()
+		degree[label] += d
+		if !selected(id) || !conf.inWindow(t) {
+			return
+		}
+		series := degrees[label]
+		if series == nil {
+			series = &DegreeSeries{Id: id}
+			degrees[label] = series
+		}
+		series.Samples = append(series.Samples, &DegreeSample{Time: t, Degree: degree[label]})
+	}
+
+	for _, ev := range events {
+		if !end.IsZero() && ev.Time.After(end) {
+			break
+		}
+		switch e := ev.Data.(type) {
+		case *NodeEvent:
+			id := e.node.Id
+			if !selected(id) {
+				continue
+			}
+			label := id.String()
+			uptime := nodes[label]
+			if uptime == nil {
+				uptime = &NodeUptime{Id: id}
+				nodes[label] = uptime
+			}
+			since, up := nodesSince[label]
+			if e.Action == "up" && !up {
+				nodesSince[label] = ev.Time
+				if conf.inWindow(ev.Time) {
+					uptime.Ups++
+				}
+			} else if e.Action == "down" && up {
+				uptime.Uptime += clip(since, ev.Time)
+				delete(nodesSince, label)
+			}
+		case *ConnEvent:
+			one, other := e.conn.One, e.conn.Other
+			label := ConnLabel(one, other)
+			since, up := connsSince[label]
+			if e.Action == "up" && !up {
+				connsSince[label] = ev.Time
+				setDegree(one, 1, ev.Time)
+				setDegree(other, 1, ev.Time)
+			} else if e.Action == "down" && up {
+				delete(connsSince, label)
+				setDegree(one, -1, ev.Time)
+				setDegree(other, -1, ev.Time)
+			} else {
+				continue
+			}
+			if !selected(one, other) {
+				continue
+			}
+			lifetime := conns[label]
+			if lifetime == nil {
+				lifetime = &ConnLifetime{One: one, Other: other}
+				conns[label] = lifetime
+			}
+			if e.Action == "up" {
+				if conf.inWindow(ev.Time) {
+					lifetime.Ups++
+				}
+			} else {
+				lifetime.Lifetime += clip(since, ev.Time)
+			}
+		}
+	}
+	for label, since := range nodesSince {
+		nodes[label].Uptime += clip(since, end)
+	}
+	for label, since := range connsSince {
+		if lifetime := conns[label]; lifetime != nil {
+			lifetime.Lifetime += clip(since, end)
+		}
+	}
+
+	result := &Aggregates{}
+	var labels []string
+	for label := range nodes {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		result.Nodes = append(result.Nodes, nodes[label])
+	}
+	labels = nil
+	for label := range conns {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		result.Conns = append(result.Conns, conns[label])
+	}
+	labels = nil
+	for label := range degrees {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		result.Degrees = append(result.Degrees, degrees[label])
+	}
+	return result
+}
+
+// NewQueryController creates a ResourceController querying the journal of
+// the network, GET (or POST) with a QueryConfig body returns the QueryResult
+func NewQueryController(journal *Journal) Controller {
+	query := &ResourceHandler{
+		Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
+			return journal.Query(msg.(*QueryConfig))
+		},
+		Type: reflect.TypeOf(&QueryConfig{}),
+	}
+	return NewResourceContoller(
+		&ResourceHandlers{
+			// GET /<networkId>/query
+			Retrieve: query,
+			// POST /<networkId>/query
+			Create: query,
+		})
+}
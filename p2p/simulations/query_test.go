@@ -0,0 +1,105 @@
+package simulations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p/adapters"
+)
+
+func TestQuery(t *testing.T) {
+	ids := RandomNodeIds(3)
+	start := time.Now()
+	at := func(s int) time.Time { return start.Add(time.Duration(s) * time.Second) }
+	node := func(s int, action string, id *adapters.NodeId) *event.Event {
+		return &event.Event{Time: at(s), Data: &NodeEvent{Type: "node", Action: action, node: &Node{Id: id}}}
+	}
+	conn := func(s int, action string, one, other *adapters.NodeId) *event.Event {
+		return &event.Event{Time: at(s), Data: &ConnEvent{Type: "conn", Action: action, conn: &Conn{One: one, Other: other}}}
+	}
+	j := NewJournal()
+	j.append(
+		node(0, "up", ids[0]),
+		node(1, "up", ids[1]),
+		node(2, "up", ids[2]),
+		conn(3, "up", ids[0], ids[1]),
+		conn(4, "up", ids[0], ids[2]),
+		conn(6, "down", ids[0], ids[1]),
+		node(7, "down", ids[1]),
+		&event.Event{Time: at(8), Data: &adapters.MsgEvent{Type: "msg", From: ids[0], To: ids[2]}},
+		node(10, "down", ids[2]),
+	)
+
+	for _, test := range []struct {
+		conf  *QueryConfig
+		total int
+		page  int
+	}{
+		{&QueryConfig{}, 9, 9},
+		{&QueryConfig{Types: []string{"conn"}}, 3, 3},
+		{&QueryConfig{Actions: []string{"down"}}, 3, 3},
+		{&QueryConfig{Types: []string{"node"}, Actions: []string{"up"}}, 3, 3},
+		{&QueryConfig{Nodes: []*adapters.NodeId{ids[2]}}, 4, 4},
+		{&QueryConfig{From: at(3), To: at(7)}, 3, 3},
+		{&QueryConfig{Offset: 2, Limit: 3}, 9, 3},
+		{&QueryConfig{Offset: 8, Limit: 3}, 9, 1},
+	} {
+		result, err := j.Query(test.conf)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.Total != test.total || len(result.Events) != test.page {
+			t.Fatalf("%+v: expected %v/%v events, got %v/%v", test.conf, test.page, test.total, len(result.Events), result.Total)
+		}
+	}
+	result, _ := j.Query(&QueryConfig{Offset: 2, Limit: 3})
+	if result.Events[0] != j.Events[2] {
+		t.Fatalf("expected page to start with the third event")
+	}
+	if _, err := j.Query(&QueryConfig{Offset: -1}); err == nil {
+		t.Fatalf("expected error for negative offset")
+	}
+	if j.NewEntries() != 9 {
+		t.Fatalf("expected query not to consume the journal")
+	}
+
+	aggregates := j.Aggregate(&QueryConfig{})
+	uptimes := make(map[string]time.Duration)
+	for _, n := range aggregates.Nodes {
+		uptimes[n.Id.String()] = n.Uptime
+	}
+	for i, exp := range []time.Duration{10 * time.Second, 6 * time.Second, 8 * time.Second} {
+		if uptimes[ids[i].String()] != exp {
+			t.Fatalf("expected uptime %v for node %v, got %v", exp, i, uptimes[ids[i].String()])
+		}
+	}
+	lifetimes := make(map[string]time.Duration)
+	for _, c := range aggregates.Conns {
+		lifetimes[ConnLabel(c.One, c.Other)] = c.Lifetime
+	}
+	if lifetimes[ConnLabel(ids[0], ids[1])] != 3*time.Second || lifetimes[ConnLabel(ids[0], ids[2])] != 6*time.Second {
+		t.Fatalf("unexpected connection lifetimes %v", lifetimes)
+	}
+	for _, d := range aggregates.Degrees {
+		if d.Id.String() != ids[0].String() {
+			continue
+		}
+		var degrees []int
+		for _, s := range d.Samples {
+			degrees = append(degrees, s.Degree)
+		}
+		if len(degrees) != 3 || degrees[0] != 1 || degrees[1] != 2 || degrees[2] != 1 {
+			t.Fatalf("unexpected degrees of node 0: %v", degrees)
+		}
+	}
+
+	// the window clips intervals
+	aggregates = j.Aggregate(&QueryConfig{Nodes: []*adapters.NodeId{ids[1]}, From: at(5), To: at(9)})
+	if len(aggregates.Nodes) != 1 || aggregates.Nodes[0].Uptime != 2*time.Second || aggregates.Nodes[0].Ups != 0 {
+		t.Fatalf("unexpected uptimes %+v", aggregates.Nodes)
+	}
+	if len(aggregates.Conns) != 1 || aggregates.Conns[0].Lifetime != time.Second {
+		t.Fatalf("unexpected lifetimes %+v", aggregates.Conns)
+	}
+}
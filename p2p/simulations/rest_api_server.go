@@ -17,6 +17,13 @@ type Controller interface {
 	SetResource(id string, c Controller)
 }
 
+// StreamController is implemented by controllers that serve GET requests
+// with a continuous stream of data rather than a single response
+type StreamController interface {
+	Controller
+	ServeStream(w http.ResponseWriter, r *http.Request)
+}
+
 // starts up http server
 func StartRestApiServer(port string, c Controller) {
 	serveMux := http.NewServeMux()
@@ -51,6 +58,10 @@ func handle(w http.ResponseWriter, r *http.Request, c Controller) {
 			return
 		}
 	}
+	if sc, ok := c.(StreamController); ok && r.Method == "GET" {
+		sc.ServeStream(w, r)
+		return
+	}
 	handler, err := c.Handle(r.Method)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("method %v not allowed (%v)", r.Method, err), http.StatusMethodNotAllowed)
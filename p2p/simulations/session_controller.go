@@ -7,6 +7,7 @@ import (
 	"io"
 	"io/ioutil"
 	"reflect"
+	"sort"
 	"sync"
 
 	"github.com/ethereum/go-ethereum/crypto"
@@ -29,13 +30,24 @@ type ResourceHandlers struct {
 type ResourceController struct {
 	lock        sync.Mutex
 	controllers map[string]Controller
+	reserved    map[string]bool // ids of resources being created
+	lookup      func(string) (Controller, error)
 	id          int
 	methods     []string
+	// the controller the resource was set on and its id there, DELETE
+	// handlers removing the resource detach it from its parent (see detach)
+	parent *ResourceController
+	name   string
 	*ResourceHandlers
 }
 
 var methodsAvailable = []string{"POST", "GET", "PUT", "DELETE"}
 
+// RawType is the ResourceHandler Type for handlers that take the request body
+// as is ([]byte) instead of decoding it as JSON
+// handlers returning an io.ReadSeeker have their response sent as is
+var RawType = reflect.TypeOf([]byte{})
+
 func (self *ResourceHandlers) handler(method string) *ResourceHandler {
 	var h *ResourceHandler
 	switch method {
@@ -61,37 +73,59 @@ func NewResourceContoller(c *ResourceHandlers) *ResourceController {
 	return &ResourceController{
 		ResourceHandlers: c,
 		controllers:      make(map[string]Controller),
+		reserved:         make(map[string]bool),
 		methods:          methods,
 	}
 }
 
 var empty = struct{}{}
 
+// NewSessionController creates the root controller hosting networks
+//
+// POST / creates a network from a NetworkConfig and returns its NetworkInfo
+// GET / lists the NetworkInfo of the networks hosted
+// DELETE / shuts down all networks and sends on the returned channel so
+// that the server can quit
 func NewSessionController() (*ResourceController, chan bool) {
 	quitc := make(chan bool)
 	return NewResourceContoller(
 		&ResourceHandlers{
-
+			// POST /
 			Create: &ResourceHandler{
 				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
 					conf := msg.(*NetworkConfig)
-					m := NewNetworkController(conf, &event.TypeMux{}, NewJournal())
-					if len(conf.Id) == 0 {
-						conf.Id = fmt.Sprintf("%d", parent.id)
+					var m *NetworkController
+					err := parent.NewResource(conf.Id, func(id string) (Controller, error) {
+						conf.Id = id
+						var err error
+						m, err = newNetwork(conf)
+						return m, err
+					})
+					if err != nil {
+						return nil, err
 					}
 					glog.V(6).Infof("new network controller on %v", conf.Id)
-					if parent != nil {
-						parent.SetResource(conf.Id, m)
-					}
-					parent.id++
-					return empty, nil
+					return m.Info(), nil
 				},
 				Type: reflect.TypeOf(&NetworkConfig{}),
 			},
-
+			// GET /
+			Retrieve: &ResourceHandler{
+				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
+					return networkInfos(parent), nil
+				},
+			},
+			// DELETE /
 			Destroy: &ResourceHandler{
 				Handle: func(msg interface{}, parent *ResourceController) (interface{}, error) {
 					glog.V(6).Infof("destroy handler called")
+					for id, c := range parent.Resources() {
+						if nc, ok := c.(*NetworkController); ok {
+							if err := nc.Shutdown(); err != nil {
+								glog.V(6).Infof("shutdown of network %v: %v", id, err)
+							}
+						}
+					}
 					// this can quit the entire app (shut down the backend server)
 					quitc <- true
 					return empty, nil
@@ -101,6 +135,55 @@ func NewSessionController() (*ResourceController, chan bool) {
 	), quitc
 }
 
+// newNetwork creates the network described by the config with its controller
+// and builds the initial topology if given
+func newNetwork(conf *NetworkConfig) (*NetworkController, error) {
+	net := NewNetwork(nil, &event.TypeMux{})
+	naf, err := nodeAdapter(net, conf)
+	if err != nil {
+		return nil, err
+	}
+	net.SetNaf(naf)
+	m := NewNetworkController(conf, net, NewJournal()).(*NetworkController)
+	if len(conf.Topology) == 0 {
+		return m, nil
+	}
+	err = func() error {
+		c, err := m.Resource("topology")
+		if err != nil {
+			return fmt.Errorf("topology resource not available")
+		}
+		h, err := c.Handle("POST")
+		if err != nil {
+			return err
+		}
+		_, err = h(bytes.NewReader(conf.Topology))
+		return err
+	}()
+	if err != nil {
+		m.Shutdown()
+		return nil, fmt.Errorf("cannot build topology: %v", err)
+	}
+	return m, nil
+}
+
+// networkInfos lists the networks hosted by the session controller ordered by id
+func networkInfos(session *ResourceController) []*NetworkInfo {
+	resources := session.Resources()
+	var ids []string
+	for id, c := range resources {
+		if _, ok := c.(*NetworkController); ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	infos := []*NetworkInfo{}
+	for _, id := range ids {
+		infos = append(infos, resources[id].(*NetworkController).Info())
+	}
+	return infos
+}
+
 func (self *ResourceController) Handle(method string) (returnHandler, error) {
 	h := self.handler(method)
 	if h == nil {
@@ -115,10 +198,12 @@ func (self *ResourceController) Handle(method string) (returnHandler, error) {
 		}
 		// glog.V(6).Infof("decode json request body")
 		var arg interface{}
-		if len(input) == 0 {
+		if h.Type == RawType {
+			arg = input
+		} else if len(input) == 0 {
 			input = []byte("{}")
 		}
-		if h.Type != nil {
+		if h.Type != nil && h.Type != RawType {
 			val := reflect.New(h.Type)
 			req := val.Elem()
 			req.Set(reflect.Zero(h.Type))
@@ -132,6 +217,9 @@ func (self *ResourceController) Handle(method string) (returnHandler, error) {
 		if err != nil {
 			return nil, err
 		}
+		if rs, ok := res.(io.ReadSeeker); ok {
+			return rs, nil
+		}
 		resp, err := json.MarshalIndent(res, "", "  ")
 		return bytes.NewReader(resp), nil
 	}
@@ -143,19 +231,125 @@ func (self *ResourceController) Resource(id string) (Controller, error) {
 	defer self.lock.Unlock()
 	c, ok := self.controllers[id]
 	if !ok {
+		if self.lookup != nil {
+			return self.lookup(id)
+		}
 		return nil, fmt.Errorf("not found")
 	}
 	return c, nil
 }
 
+// SetResourceLookup sets a function to resolve resource ids that have no
+// controller set explicitly, used for resources that exist independently
+// of the REST API (e.g., nodes of a network)
+func (self *ResourceController) SetResourceLookup(f func(id string) (Controller, error)) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.lookup = f
+}
+
 func (self *ResourceController) SetResource(id string, c Controller) {
 	self.lock.Lock()
 	defer self.lock.Unlock()
 	if c == nil {
 		delete(self.controllers, id)
-	} else {
-		self.controllers[id] = c
+		return
+	}
+	self.controllers[id] = c
+	if a, ok := c.(adopter); ok {
+		a.adopt(self, id)
+	}
+}
+
+// NewResource sets the controller returned by create under the id given or
+// the next numbered id if empty, the id is reserved under the lock so that of
+// concurrent creations of the same id only one succeeds, the controller is
+// created without holding the lock and set once created
+func (self *ResourceController) NewResource(id string, create func(id string) (Controller, error)) error {
+	id, n, err := self.reserve(id)
+	if err != nil {
+		return err
+	}
+	c, err := create(id)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.reserved, id)
+	if err != nil {
+		// the number is given to the next resource unless already taken
+		if self.id == n+1 {
+			self.id = n
+		}
+		return err
+	}
+	self.controllers[id] = c
+	if a, ok := c.(adopter); ok {
+		a.adopt(self, id)
+	}
+	return nil
+}
+
+// reserve takes the id (the next numbered id if empty) for a resource being
+// created, it returns the id and the number of the resource
+func (self *ResourceController) reserve(id string) (string, int, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if len(id) == 0 {
+		id = fmt.Sprintf("%d", self.id)
 	}
+	if _, ok := self.controllers[id]; ok || self.reserved[id] {
+		return "", 0, fmt.Errorf("resource %v already exists", id)
+	}
+	self.reserved[id] = true
+	n := self.id
+	self.id++
+	return id, n, nil
+}
+
+// adopter is implemented by ResourceController (and the controllers embedding it)
+type adopter interface {
+	adopt(parent *ResourceController, id string)
+	base() *ResourceController
+}
+
+func (self *ResourceController) base() *ResourceController {
+	return self
+}
+
+func (self *ResourceController) adopt(parent *ResourceController, id string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.parent = parent
+	self.name = id
+}
+
+// detach removes the resource from its parent, it is called by the DELETE
+// handlers of resources that are removed, as opposed to those that stop or
+// undo something (e.g., healing a partition)
+func (self *ResourceController) detach() {
+	self.lock.Lock()
+	parent, name := self.parent, self.name
+	self.parent = nil
+	self.lock.Unlock()
+	if parent == nil {
+		return
+	}
+	parent.lock.Lock()
+	defer parent.lock.Unlock()
+	// the resource may have been replaced in the meantime
+	if a, ok := parent.controllers[name].(adopter); ok && a.base() == self {
+		delete(parent.controllers, name)
+	}
+}
+
+// Resources returns the resources set on the controller by id
+func (self *ResourceController) Resources() map[string]Controller {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	resources := make(map[string]Controller)
+	for id, c := range self.controllers {
+		resources[id] = c
+	}
+	return resources
 }
 
 func (self *ResourceController) DeleteResource(id string) {
@@ -171,6 +365,19 @@ func RandomNodeId() *adapters.NodeId {
 	return adapters.NewNodeId(pubkey[1:])
 }
 
+// RandomNodeConfig returns the config of a node with a random key
+func RandomNodeConfig() *NodeConfig {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		panic("unable to generate key")
+	}
+	pubkey := crypto.FromECDSAPub(&key.PublicKey)
+	return &NodeConfig{
+		Id:         adapters.NewNodeId(pubkey[1:]),
+		PrivateKey: key,
+	}
+}
+
 func RandomNodeIds(n int) []*adapters.NodeId {
 	var ids []*adapters.NodeId
 	for i := 0; i < n; i++ {
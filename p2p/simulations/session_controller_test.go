@@ -1,11 +1,14 @@
 package simulations
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
@@ -85,7 +88,7 @@ func TestUpdate(t *testing.T) {
 	conf := &NetworkConfig{
 		Id: "0",
 	}
-	mc := NewNetworkController(conf, &event.TypeMux{}, journal)
+	mc := NewNetworkController(conf, NewNetwork(nil, &event.TypeMux{}), journal)
 	controller.SetResource(conf.Id, mc)
 	exp := `{
   "add": [
@@ -106,10 +109,20 @@ func TestUpdate(t *testing.T) {
   ],
   "remove": []
 }`
+	n := journal.NewEntries()
 	resp := testResponse(t, "GET", url(port, "0"), bytes.NewReader([]byte("{}")))
 	if string(resp) != exp {
 		t.Fatalf("incorrect response body. got\n'%v', expected\n'%v'", string(resp), exp)
 	}
+	// the cytoscape view does not consume the events of other readers
+	if m := journal.NewEntries(); m != n {
+		t.Fatalf("expected %v new entries after update, got %v", n, m)
+	}
+	// but only gets the changes since its last update
+	resp = testResponse(t, "GET", url(port, "0"), bytes.NewReader([]byte("{}")))
+	if exp := "{\n  \"add\": [],\n  \"remove\": []\n}"; string(resp) != exp {
+		t.Fatalf("incorrect response body. got\n'%v', expected\n'%v'", string(resp), exp)
+	}
 }
 
 func mockNewNodes(eventer *event.TypeMux, ids []*adapters.NodeId) {
@@ -123,3 +136,379 @@ func mockNewNodes(eventer *event.TypeMux, ids []*adapters.NodeId) {
 		})
 	}
 }
+
+func TestNodesController(t *testing.T) {
+	net := NewNetwork(nil, &event.TypeMux{})
+	net.SetNaf(func(conf *NodeConfig) adapters.NodeAdapter {
+		return adapters.NewSimNode(conf.Id, net, &adapters.SimPipe{})
+	})
+	conf := &NetworkConfig{Id: "nodes"}
+	controller.SetResource(conf.Id, NewNetworkController(conf, net, NewJournal()))
+
+	ids := testIDs()
+	for _, id := range ids {
+		body := fmt.Sprintf(`{"Id": "%v"}`, id)
+		testResponse(t, "POST", url(port, "nodes/nodes"), bytes.NewReader([]byte(body)))
+		path := fmt.Sprintf("nodes/nodes/%v", id)
+		testResponse(t, "PUT", url(port, path), bytes.NewReader([]byte(`{"up": true}`)))
+		if node := net.GetNode(id); node == nil || !node.Up {
+			t.Fatalf("node %v not up", id)
+		}
+	}
+	connPath := fmt.Sprintf("nodes/nodes/%v/conns/%v", ids[0], ids[1])
+	testResponse(t, "PUT", url(port, connPath), nil)
+	if conn := net.GetConn(ids[0], ids[1]); conn == nil || !conn.Up {
+		t.Fatalf("conn %v-%v not up", ids[0], ids[1])
+	}
+	testResponse(t, "DELETE", url(port, connPath), nil)
+	if conn := net.GetConn(ids[0], ids[1]); conn.Up {
+		t.Fatalf("conn %v-%v not down", ids[0], ids[1])
+	}
+	testResponse(t, "DELETE", url(port, fmt.Sprintf("nodes/nodes/%v", ids[0])), nil)
+	if net.GetNode(ids[0]) != nil {
+		t.Fatalf("node %v not deleted", ids[0])
+	}
+	if len(net.GetNodes()) != 1 || len(net.GetConns()) != 0 {
+		t.Fatalf("incorrect network after delete: %v nodes, %v conns", len(net.GetNodes()), len(net.GetConns()))
+	}
+}
+
+func TestJournalUploadDownload(t *testing.T) {
+	ids := testIDs()
+	journal := testJournal(ids)
+	journal.Id = "uploaded"
+	conf := &NetworkConfig{Id: "journals"}
+	controller.SetResource(conf.Id, NewNetworkController(conf, NewNetwork(nil, &event.TypeMux{}), journal))
+
+	// GET /<networkId>/journal
+	down := testResponse(t, "GET", url(port, "journals/journal"), nil)
+	exp := &bytes.Buffer{}
+	if _, err := journal.WriteTo(exp); err != nil {
+		t.Fatalf("unexpected error encoding journal: %v", err)
+	}
+	if !bytes.Equal(down, exp.Bytes()) {
+		t.Fatalf("incorrect journal download: expected\n%s\ngot\n%s", exp.Bytes(), down)
+	}
+
+	// POST /<networkId>/journal
+	resp := testResponse(t, "POST", url(port, "journals/journal"), bytes.NewReader(down))
+	var created struct{ Id string }
+	if err := json.Unmarshal(resp, &created); err != nil || created.Id != journal.Id {
+		t.Fatalf("expected journal %v created, got %s (%v)", journal.Id, resp, err)
+	}
+	// GET /<networkId>/journal/<journalId> returns the journal uploaded
+	up := testResponse(t, "GET", url(port, "journals/journal/"+created.Id), nil)
+	if !bytes.Equal(up, down) {
+		t.Fatalf("incorrect uploaded journal: expected\n%s\ngot\n%s", down, up)
+	}
+	j := NewJournal()
+	if _, err := j.ReadFrom(bytes.NewReader(up)); err != nil {
+		t.Fatalf("unexpected error decoding journal: %v", err)
+	}
+	if len(j.Events) != len(journal.Events) {
+		t.Fatalf("expected %v events, got %v", len(journal.Events), len(j.Events))
+	}
+
+	// ids are unique, the journal can be deleted
+	req, _ := http.NewRequest("POST", url(port, "journals/journal"), bytes.NewReader(down))
+	if r, err := (&http.Client{}).Do(req); err != nil || r.StatusCode == http.StatusOK {
+		t.Fatalf("expected error uploading journal %v twice", created.Id)
+	}
+	testResponse(t, "DELETE", url(port, "journals/journal/"+created.Id), nil)
+	req, _ = http.NewRequest("GET", url(port, "journals/journal/"+created.Id), nil)
+	if r, err := (&http.Client{}).Do(req); err != nil || r.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected journal %v deleted", created.Id)
+	}
+}
+
+func TestEventsStream(t *testing.T) {
+	eventer := &event.TypeMux{}
+	journal := NewJournal()
+	conf := &NetworkConfig{Id: "events"}
+	controller.SetResource(conf.Id, NewNetworkController(conf, NewNetwork(nil, eventer), journal))
+	ids := testIDs()
+	mockNewNodes(eventer, ids[:1])
+	journal.WaitEntries(1)
+
+	r := openEventsStream(t, "events/events?from=0")
+	defer r.Close()
+	// replayed from the journal
+	id, got := r.readEvent()
+	if id != "0" || !strings.Contains(got, ids[0].String()) {
+		t.Fatalf("replayed event should be 0 and contain node id %v, got %v %v", ids[0], id, got)
+	}
+	// live
+	mockNewNodes(eventer, ids[1:])
+	id, got = r.readEvent()
+	if id != "1" || !strings.Contains(got, ids[1].String()) {
+		t.Fatalf("live event should be 1 and contain node id %v, got %v %v", ids[1], id, got)
+	}
+
+	// without an offset the ids continue those of the journal
+	journal.WaitEntries(2)
+	r2 := openEventsStream(t, "events/events")
+	defer r2.Close()
+	mockNewNodes(eventer, ids[:1])
+	if id, _ := r2.readEvent(); id != "2" {
+		t.Fatalf("expected event 2, got %v", id)
+	}
+}
+
+type eventsStream struct {
+	*bufio.Reader
+	io.Closer
+	t *testing.T
+}
+
+func openEventsStream(t *testing.T, path string) *eventsStream {
+	resp, err := http.Get(url(port, path))
+	if err != nil {
+		t.Fatalf("unexpected error on request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		t.Fatalf("unexpected status: %v", resp.StatusCode)
+	}
+	return &eventsStream{bufio.NewReader(resp.Body), resp.Body, t}
+}
+
+// readEvent returns the id and data of the next event of the stream
+func (self *eventsStream) readEvent() (id, data string) {
+	for {
+		line, err := self.ReadString('\n')
+		if err != nil {
+			self.t.Fatalf("unexpected error reading stream: %v", err)
+		}
+		if line == "\n" {
+			return id, data
+		}
+		if strings.HasPrefix(line, "id: ") {
+			id = strings.TrimSpace(strings.TrimPrefix(line, "id: "))
+		}
+		if strings.HasPrefix(line, "data: ") {
+			data = strings.TrimSpace(strings.TrimPrefix(line, "data: "))
+		}
+	}
+}
+
+func TestReportController(t *testing.T) {
+	eventer := &event.TypeMux{}
+	journal := NewJournal()
+	journal.Subscribe(eventer, ConnectivityEvents...)
+	net := NewNetwork(nil, eventer)
+	net.SetNaf(func(conf *NodeConfig) adapters.NodeAdapter {
+		return adapters.NewSimNode(conf.Id, net, &adapters.SimPipe{})
+	})
+	conf := &NetworkConfig{Id: "report"}
+	controller.SetResource(conf.Id, NewNetworkController(conf, net, NewJournal()))
+	ids := testIDs()
+	for _, id := range ids {
+		if err := net.NewNode(&NodeConfig{Id: id}); err != nil {
+			t.Fatalf("unexpected error creating node: %v", err)
+		}
+	}
+	reporter := adapters.NewRemoteReporter(url(port, "report/report"))
+	if err := reporter.DidConnect(ids[1], ids[0]); err != nil {
+		t.Fatalf("unexpected error reporting connection: %v", err)
+	}
+	conn := net.GetConn(ids[0], ids[1])
+	if conn == nil || !conn.Up {
+		t.Fatalf("conn %v-%v not up", ids[0], ids[1])
+	}
+	caller := conn.One
+	if conn.Reverse {
+		caller = conn.Other
+	}
+	if caller.NodeID != ids[1].NodeID {
+		t.Fatalf("expected caller %v, got %v", ids[1], caller)
+	}
+	// the event carries the time of the report
+	journal.WaitEntries(1)
+	journal.Read(func(ev *event.Event) bool {
+		if conn := ev.Data.(*ConnEvent); conn.Reported.IsZero() || conn.Reported.After(ev.Time) {
+			t.Fatalf("expected report time before event time %v, got %v", ev.Time, conn.Reported)
+		}
+		return true
+	})
+	if err := reporter.DidConnect(ids[1], ids[0]); err == nil {
+		t.Fatalf("expected error reporting connection twice")
+	}
+	if err := reporter.DidDisconnect(ids[0], ids[1]); err != nil {
+		t.Fatalf("unexpected error reporting disconnection: %v", err)
+	}
+	if conn.Up {
+		t.Fatalf("conn %v-%v not down", ids[0], ids[1])
+	}
+}
+
+func TestNetworks(t *testing.T) {
+	resp := testResponse(t, "POST", url(port, ""), bytes.NewReader([]byte(`{"Id": "session"}`)))
+	var info NetworkInfo
+	if err := json.Unmarshal(resp, &info); err != nil {
+		t.Fatalf("unexpected response %s: %v", resp, err)
+	}
+	if info.Id != "session" || info.Status != "idle" {
+		t.Fatalf("unexpected network info %+v", info)
+	}
+	resp = testResponse(t, "POST", url(port, ""), bytes.NewReader([]byte(`{"Id": "session"}`)))
+	if !strings.Contains(string(resp), "already exists") {
+		t.Fatalf("expected error creating network twice, got %s", resp)
+	}
+	resp = testResponse(t, "POST", url(port, ""), bytes.NewReader([]byte(`{"Adapter": "unknown"}`)))
+	if !strings.Contains(string(resp), "unknown node adapter") {
+		t.Fatalf("expected error for unknown adapter, got %s", resp)
+	}
+
+	ids := testIDs()
+	for _, id := range ids {
+		testResponse(t, "POST", url(port, "session/nodes"), bytes.NewReader([]byte(fmt.Sprintf(`{"Id": "%v"}`, id))))
+		testResponse(t, "PUT", url(port, fmt.Sprintf("session/nodes/%v", id)), bytes.NewReader([]byte(`{"up": true}`)))
+	}
+	testResponse(t, "PUT", url(port, fmt.Sprintf("session/nodes/%v/conns/%v", ids[0], ids[1])), nil)
+
+	list := func() map[string]*NetworkInfo {
+		var infos []*NetworkInfo
+		resp := testResponse(t, "GET", url(port, ""), nil)
+		if err := json.Unmarshal(resp, &infos); err != nil {
+			t.Fatalf("unexpected response %s: %v", resp, err)
+		}
+		networks := make(map[string]*NetworkInfo)
+		for _, info := range infos {
+			networks[info.Id] = info
+		}
+		return networks
+	}
+	got := list()["session"]
+	exp := &NetworkInfo{Id: "session", Nodes: 2, NodesUp: 2, Conns: 1, ConnsUp: 1, Status: "running"}
+	if got == nil || *got != *exp {
+		t.Fatalf("expected network info %+v, got %+v", exp, got)
+	}
+
+	c, err := controller.Resource("session")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	nc := c.(*NetworkController)
+	testResponse(t, "DELETE", url(port, "session"), nil)
+	if _, ok := list()["session"]; ok {
+		t.Fatalf("expected network to be removed")
+	}
+	for _, node := range nc.net.GetNodes() {
+		if node.Up {
+			t.Fatalf("expected node %v to be stopped", node.Id)
+		}
+	}
+	if info := nc.Info(); info.Status != "shutdown" || info.ConnsUp != 0 {
+		t.Fatalf("unexpected network info after shutdown %+v", info)
+	}
+}
+
+func TestNetworksConcurrentCreate(t *testing.T) {
+	// of concurrent creations of the same network only one succeeds
+	n := 8
+	respc := make(chan []byte, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			respc <- testResponse(t, "POST", url(port, ""), bytes.NewReader([]byte(`{"Id": "concurrent"}`)))
+		}()
+	}
+	var created int
+	for i := 0; i < n; i++ {
+		resp := <-respc
+		var info NetworkInfo
+		if err := json.Unmarshal(resp, &info); err == nil && info.Id == "concurrent" {
+			created++
+		} else if !strings.Contains(string(resp), "already exists") {
+			t.Fatalf("unexpected response %s", resp)
+		}
+	}
+	if created != 1 {
+		t.Fatalf("expected one network created, got %v", created)
+	}
+	testResponse(t, "DELETE", url(port, "concurrent"), nil)
+}
+
+func testStatus(t *testing.T, method, addr string, body []byte) int {
+	req, err := http.NewRequest(method, addr, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("unexpected error creating request: %v", err)
+	}
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error on http.Client request: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestDeleteSubresource(t *testing.T) {
+	testResponse(t, "POST", url(port, ""), bytes.NewReader([]byte(`{"Id": "healed"}`)))
+	defer testResponse(t, "DELETE", url(port, "healed"), nil)
+	// healing does not remove the partition resource
+	for i := 0; i < 2; i++ {
+		if status := testStatus(t, "PUT", url(port, "healed/partition"), []byte(`{"groups": []}`)); status != http.StatusOK {
+			t.Fatalf("expected partition, got status %v", status)
+		}
+		for j := 0; j < 2; j++ {
+			if status := testStatus(t, "DELETE", url(port, "healed/partition"), nil); status != http.StatusOK {
+				t.Fatalf("expected heal, got status %v", status)
+			}
+		}
+	}
+	// deleting the network removes it
+	if status := testStatus(t, "DELETE", url(port, "healed"), nil); status != http.StatusOK {
+		t.Fatalf("expected network deleted, got status %v", status)
+	}
+	if status := testStatus(t, "GET", url(port, "healed"), nil); status != http.StatusNotFound {
+		t.Fatalf("expected network not found, got status %v", status)
+	}
+}
+
+func TestNewResource(t *testing.T) {
+	c := NewResourceContoller(&ResourceHandlers{})
+	startedc := make(chan bool)
+	createc := make(chan bool)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.NewResource("", func(id string) (Controller, error) {
+			close(startedc)
+			<-createc
+			return NewResourceContoller(&ResourceHandlers{}), nil
+		})
+	}()
+	<-startedc
+	// the controller is usable while a resource is created, its id is taken
+	if resources := c.Resources(); len(resources) != 0 {
+		t.Fatalf("expected no resources, got %v", resources)
+	}
+	if err := c.NewResource("0", func(id string) (Controller, error) {
+		return NewResourceContoller(&ResourceHandlers{}), nil
+	}); err == nil {
+		t.Fatalf("expected id 0 to be reserved")
+	}
+	// failed creations free their id
+	failed := fmt.Errorf("failed")
+	if err := c.NewResource("", func(id string) (Controller, error) {
+		if id != "1" {
+			t.Fatalf("expected id 1, got %v", id)
+		}
+		return nil, failed
+	}); err != failed {
+		t.Fatalf("expected %v, got %v", failed, err)
+	}
+	close(createc)
+	if err := <-errc; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.NewResource("", func(id string) (Controller, error) {
+		if id != "1" {
+			t.Fatalf("expected id 1, got %v", id)
+		}
+		return NewResourceContoller(&ResourceHandlers{}), nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resources := c.Resources(); len(resources) != 2 || resources["0"] == nil || resources["1"] == nil {
+		t.Fatalf("expected resources 0 and 1, got %v", resources)
+	}
+}
@@ -0,0 +1,299 @@
+// Package topology generates network topologies for simulations
+//
+// generators return the edges of a graph over n nodes as pairs of node indexes,
+// Build creates the nodes in a simulations.Network (using the node adapter
+// function of the network), starts them and connects them along the edges
+//
+// importing the package registers the topology resource with network controllers:
+// POST /<networkId>/topology builds the topology described by the Config body
+package topology
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/p2p/adapters"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+)
+
+// Config describes a topology, Type is the name of the generator
+// (see Register), the other parameters are only used by some generators
+// random generators are seeded with Seed so that the same config always
+// results in the same edges
+type Config struct {
+	Type  string  `json:"type"`
+	Nodes int     `json:"nodes"`
+	P     float64 `json:"p"`     // probability of an edge (random)
+	M     int     `json:"m"`     // edges per new node (scalefree)
+	K     int     `json:"k"`     // degree of nodes (regular)
+	Width int     `json:"width"` // number of nodes in a row (grid)
+	Seed  int64   `json:"seed"`
+}
+
+// Generator returns the edges of a topology over n nodes as pairs of node indexes
+type Generator func(n int, conf *Config, r *rand.Rand) ([][2]int, error)
+
+var (
+	generatorsLock sync.RWMutex
+	generators     = map[string]Generator{
+		"ring":  func(n int, conf *Config, r *rand.Rand) ([][2]int, error) { return Ring(n), nil },
+		"chain": func(n int, conf *Config, r *rand.Rand) ([][2]int, error) { return Chain(n), nil },
+		"star":  func(n int, conf *Config, r *rand.Rand) ([][2]int, error) { return Star(n), nil },
+		"mesh":  func(n int, conf *Config, r *rand.Rand) ([][2]int, error) { return Mesh(n), nil },
+		"random": func(n int, conf *Config, r *rand.Rand) ([][2]int, error) {
+			return ErdosRenyi(n, conf.P, r), nil
+		},
+		"scalefree": func(n int, conf *Config, r *rand.Rand) ([][2]int, error) {
+			return BarabasiAlbert(n, conf.M, r)
+		},
+		"regular": func(n int, conf *Config, r *rand.Rand) ([][2]int, error) {
+			return Regular(n, conf.K, r)
+		},
+		"grid": func(n int, conf *Config, r *rand.Rand) ([][2]int, error) {
+			return Grid(n, conf.Width)
+		},
+	}
+)
+
+// Register makes a generator available under the given name
+func Register(name string, g Generator) {
+	generatorsLock.Lock()
+	defer generatorsLock.Unlock()
+	generators[name] = g
+}
+
+// Edges returns the edges of the topology described by the config
+func Edges(conf *Config) ([][2]int, error) {
+	generatorsLock.RLock()
+	g, ok := generators[conf.Type]
+	generatorsLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown topology '%v'", conf.Type)
+	}
+	if conf.Nodes < 0 {
+		return nil, fmt.Errorf("invalid number of nodes: %v", conf.Nodes)
+	}
+	return g(conf.Nodes, conf, rand.New(rand.NewSource(conf.Seed)))
+}
+
+// Topology is the result of building a topology, the nodes created and the
+// edges between them given as indexes of Nodes
+type Topology struct {
+	Nodes []*adapters.NodeId `json:"nodes"`
+	Edges [][2]int           `json:"edges"`
+}
+
+// Build creates and starts the nodes of the topology described by the config
+// in the network and connects them, the node with the lower index dials
+// if the build fails the nodes created so far are deleted (with their
+// connections) so that the network is left as it was
+func Build(net *simulations.Network, conf *Config) (top *Topology, err error) {
+	edges, err := Edges(conf)
+	if err != nil {
+		return nil, err
+	}
+	var ids []*adapters.NodeId
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, id := range ids {
+			if derr := net.DeleteNode(id); derr != nil {
+				glog.V(6).Infof("cannot delete node %v of failed %v topology: %v", id, conf.Type, derr)
+			}
+		}
+	}()
+	for i := 0; i < conf.Nodes; i++ {
+		node := simulations.RandomNodeConfig()
+		if err := net.NewNode(node); err != nil {
+			return nil, err
+		}
+		ids = append(ids, node.Id)
+		if err := net.Start(node.Id); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range edges {
+		if err := net.Connect(ids[e[0]], ids[e[1]]); err != nil {
+			return nil, fmt.Errorf("cannot connect %v to %v: %v", ids[e[0]], ids[e[1]], err)
+		}
+	}
+	glog.V(6).Infof("built %v topology: %v nodes, %v edges", conf.Type, len(ids), len(edges))
+	return &Topology{Nodes: ids, Edges: edges}, nil
+}
+
+// NewTopologyController creates a ResourceController for building topologies
+// in the network, POST builds the topology described by the Config given as body
+func NewTopologyController(net *simulations.Network) simulations.Controller {
+	return simulations.NewResourceContoller(
+		&simulations.ResourceHandlers{
+			// POST /<networkId>/topology
+			Create: &simulations.ResourceHandler{
+				Handle: func(msg interface{}, parent *simulations.ResourceController) (interface{}, error) {
+					return Build(net, msg.(*Config))
+				},
+				Type: reflect.TypeOf(&Config{}),
+			},
+		})
+}
+
+func init() {
+	simulations.RegisterNetworkResource("topology", NewTopologyController)
+}
+
+// Ring connects each node to the next one and the last one to the first
+func Ring(n int) [][2]int {
+	edges := Chain(n)
+	if n > 2 {
+		edges = append(edges, [2]int{n - 1, 0})
+	}
+	return edges
+}
+
+// Chain connects each node to the next one
+func Chain(n int) (edges [][2]int) {
+	for i := 0; i < n-1; i++ {
+		edges = append(edges, [2]int{i, i + 1})
+	}
+	return edges
+}
+
+// Star connects the first node to all the others
+func Star(n int) (edges [][2]int) {
+	for i := 1; i < n; i++ {
+		edges = append(edges, [2]int{0, i})
+	}
+	return edges
+}
+
+// Mesh connects every node to every other node
+func Mesh(n int) (edges [][2]int) {
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			edges = append(edges, [2]int{i, j})
+		}
+	}
+	return edges
+}
+
+// ErdosRenyi connects each pair of nodes with probability p
+func ErdosRenyi(n int, p float64, r *rand.Rand) (edges [][2]int) {
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			if r.Float64() < p {
+				edges = append(edges, [2]int{i, j})
+			}
+		}
+	}
+	return edges
+}
+
+// BarabasiAlbert creates a scale-free network by preferential attachment
+// the first m+1 nodes form a mesh, each further node connects to m distinct
+// earlier nodes chosen with probability proportional to their degree
+func BarabasiAlbert(n, m int, r *rand.Rand) ([][2]int, error) {
+	if m < 1 {
+		return nil, fmt.Errorf("invalid number of edges per node: %v", m)
+	}
+	if n <= m+1 {
+		return Mesh(n), nil
+	}
+	edges := Mesh(m + 1)
+	// each node appears once for every edge it has
+	var ends []int
+	for _, e := range edges {
+		ends = append(ends, e[0], e[1])
+	}
+	for i := m + 1; i < n; i++ {
+		targets := make(map[int]bool)
+		var chosen []int
+		for len(chosen) < m {
+			j := ends[r.Intn(len(ends))]
+			if targets[j] {
+				continue
+			}
+			targets[j] = true
+			chosen = append(chosen, j)
+		}
+		for _, j := range chosen {
+			edges = append(edges, [2]int{j, i})
+			ends = append(ends, j, i)
+		}
+	}
+	return edges, nil
+}
+
+// Regular creates a random k-regular graph, starting from a ring lattice
+// edges are randomised by swaps that preserve the degree of nodes
+func Regular(n, k int, r *rand.Rand) ([][2]int, error) {
+	if k < 0 || k >= n && n > 0 {
+		return nil, fmt.Errorf("invalid degree %v for %v nodes", k, n)
+	}
+	if n*k%2 != 0 {
+		return nil, fmt.Errorf("no %v-regular graph over %v nodes", k, n)
+	}
+	var edges [][2]int
+	connected := make(map[[2]int]bool)
+	add := func(i, j int) {
+		if i > j {
+			i, j = j, i
+		}
+		connected[[2]int{i, j}] = true
+		edges = append(edges, [2]int{i, j})
+	}
+	for i := 0; i < n; i++ {
+		for d := 1; d <= k/2; d++ {
+			add(i, (i+d)%n)
+		}
+		// odd degree (n is even): connect opposite nodes
+		if k%2 == 1 && i < n/2 {
+			add(i, i+n/2)
+		}
+	}
+	// double edge swaps: (a,b),(c,d) -> (a,d),(c,b)
+	for s := 0; s < 10*len(edges); s++ {
+		x, y := r.Intn(len(edges)), r.Intn(len(edges))
+		a, b := edges[x][0], edges[x][1]
+		c, d := edges[y][0], edges[y][1]
+		if r.Intn(2) == 0 {
+			c, d = d, c
+		}
+		if a == d || c == b || connected[ordered(a, d)] || connected[ordered(c, b)] {
+			continue
+		}
+		delete(connected, ordered(a, b))
+		delete(connected, ordered(c, d))
+		connected[ordered(a, d)] = true
+		connected[ordered(c, b)] = true
+		edges[x] = ordered(a, d)
+		edges[y] = ordered(c, b)
+	}
+	return edges, nil
+}
+
+// Grid arranges the nodes in rows of the given width and connects each node
+// to its right and lower neighbours
+func Grid(n, width int) (edges [][2]int, err error) {
+	if width < 1 {
+		return nil, fmt.Errorf("invalid grid width: %v", width)
+	}
+	for i := 0; i < n; i++ {
+		if (i+1)%width != 0 && i+1 < n {
+			edges = append(edges, [2]int{i, i + 1})
+		}
+		if i+width < n {
+			edges = append(edges, [2]int{i, i + width})
+		}
+	}
+	return edges, nil
+}
+
+func ordered(i, j int) [2]int {
+	if i > j {
+		return [2]int{j, i}
+	}
+	return [2]int{i, j}
+}
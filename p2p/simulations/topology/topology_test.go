@@ -0,0 +1,135 @@
+package topology
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p/adapters"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+)
+
+func degrees(n int, edges [][2]int) []int {
+	degrees := make([]int, n)
+	seen := make(map[[2]int]bool)
+	for _, e := range edges {
+		if e[0] == e[1] || seen[ordered(e[0], e[1])] {
+			panic("self loop or multiple edge")
+		}
+		seen[ordered(e[0], e[1])] = true
+		degrees[e[0]]++
+		degrees[e[1]]++
+	}
+	return degrees
+}
+
+func TestGenerators(t *testing.T) {
+	for _, test := range []struct {
+		conf  *Config
+		edges int
+	}{
+		{&Config{Type: "ring", Nodes: 10}, 10},
+		{&Config{Type: "chain", Nodes: 10}, 9},
+		{&Config{Type: "star", Nodes: 10}, 9},
+		{&Config{Type: "mesh", Nodes: 10}, 45},
+		{&Config{Type: "random", Nodes: 10, P: 1}, 45},
+		{&Config{Type: "random", Nodes: 10, P: 0}, 0},
+		{&Config{Type: "scalefree", Nodes: 10, M: 2}, 3 + 7*2},
+		{&Config{Type: "regular", Nodes: 10, K: 3}, 15},
+		{&Config{Type: "grid", Nodes: 12, Width: 4}, 3*3 + 2*4},
+	} {
+		edges, err := Edges(test.conf)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", test.conf.Type, err)
+		}
+		if len(edges) != test.edges {
+			t.Fatalf("%v: expected %v edges, got %v", test.conf.Type, test.edges, len(edges))
+		}
+		degrees(test.conf.Nodes, edges)
+	}
+}
+
+func TestRegular(t *testing.T) {
+	_, err := Regular(21, 5, rand.New(rand.NewSource(1)))
+	if err == nil {
+		t.Fatalf("expected error for odd number of edge ends")
+	}
+	edges, err := Regular(20, 4, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, d := range degrees(20, edges) {
+		if d != 4 {
+			t.Fatalf("expected degree 4 for node %v, got %v", i, d)
+		}
+	}
+	again, _ := Regular(20, 4, rand.New(rand.NewSource(1)))
+	if !reflect.DeepEqual(edges, again) {
+		t.Fatalf("expected the same edges for the same seed")
+	}
+}
+
+func TestBuild(t *testing.T) {
+	net := simulations.NewNetwork(nil, &event.TypeMux{})
+	net.SetNaf(func(conf *simulations.NodeConfig) adapters.NodeAdapter {
+		return adapters.NewSimNode(conf.Id, net, &adapters.SimPipe{})
+	})
+	top, err := Build(net, &Config{Type: "ring", Nodes: 5})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(net.GetNodes()) != 5 {
+		t.Fatalf("expected 5 nodes, got %v", len(net.GetNodes()))
+	}
+	for _, e := range top.Edges {
+		conn := net.GetConn(top.Nodes[e[0]], top.Nodes[e[1]])
+		if conn == nil || !conn.Up {
+			t.Fatalf("expected nodes %v and %v to be connected", e[0], e[1])
+		}
+	}
+}
+
+func TestBuildRollback(t *testing.T) {
+	net := simulations.NewNetwork(nil, &event.TypeMux{})
+	var created int
+	net.SetNaf(func(conf *simulations.NodeConfig) adapters.NodeAdapter {
+		if created == 3 {
+			return nil
+		}
+		created++
+		return adapters.NewSimNode(conf.Id, net, &adapters.SimPipe{})
+	})
+	if _, err := Build(net, &Config{Type: "ring", Nodes: 5}); err == nil {
+		t.Fatalf("expected error building topology")
+	}
+	if len(net.GetNodes()) != 0 || len(net.GetConns()) != 0 {
+		t.Fatalf("expected empty network, got %v nodes, %v conns", len(net.GetNodes()), len(net.GetConns()))
+	}
+}
+
+func TestSessionTopology(t *testing.T) {
+	session, _ := simulations.NewSessionController()
+	h, err := session.Handle("POST")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := h(bytes.NewReader([]byte(`{"Id": "ring", "Topology": {"type": "ring", "nodes": 4}}`))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c, err := session.Resource("ring")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	info := c.(*simulations.NetworkController).Info()
+	if info.NodesUp != 4 || info.ConnsUp != 4 {
+		t.Fatalf("expected 4 nodes and 4 connections up, got %+v", info)
+	}
+	if _, err := h(bytes.NewReader([]byte(`{"Id": "bad", "Topology": {"type": "unknown"}}`))); err == nil {
+		t.Fatalf("expected error for unknown topology")
+	}
+	if _, err := session.Resource("bad"); err == nil {
+		t.Fatalf("expected network not to be added")
+	}
+}
@@ -26,7 +26,28 @@ type ExchangeTestSession struct {
 	Ids  []*adapters.NodeId
 	TestNetAdapter
 	TestMessenger
-	t *testing.T
+	Timeouts Timeouts
+	t        *testing.T
+	inboxes  map[p2p.MsgReadWriter]*inbox
+}
+
+// Timeouts configures the waits of a session, zero values mean the defaults
+// timeouts of individual triggers and expects override the session defaults
+type Timeouts struct {
+	Trigger    time.Duration // default timeout of triggers (1000ms)
+	Expect     time.Duration // default timeout of expects (1000ms)
+	Absent     time.Duration // default wait of absent expects (100ms)
+	Exchange   time.Duration // global timeout of exchanges, extended to the longest timeout of their triggers and expects (1000ms)
+	Connect    time.Duration // timeout of peers connecting or flushing (1000ms)
+	Disconnect time.Duration // timeout of each peer disconnecting (1000ms)
+}
+
+// or returns the timeout d, the default if d is zero
+func or(d, def time.Duration) time.Duration {
+	if d == time.Duration(0) {
+		return def
+	}
+	return d
 }
 
 // implemented by simulations/
@@ -55,11 +76,17 @@ type Trigger struct {
 	Timeout time.Duration    // timeout duration for the sending
 }
 
+// Expect is by default satisfied by the message Msg arriving on the peer
+// alternatively, the message can be accepted by a predicate (Match), a set of
+// messages can be expected in any order (Unordered) or no message at all (Absent)
 type Expect struct {
-	Msg     interface{}      // type of message to expect
-	Code    uint64           // code of message is now given
-	Peer    *adapters.NodeId // the peer that expects the message
-	Timeout time.Duration    // timeout duration for receiving
+	Msg       interface{}            // type of message to expect
+	Code      uint64                 // code of message is now given
+	Peer      *adapters.NodeId       // the peer that expects the message
+	Timeout   time.Duration          // timeout duration for receiving
+	Match     func(interface{}) bool // accepts the message decoded into the type of Msg instead of equality
+	Unordered []*ExpectedMsg         // messages expected in any order instead of Msg
+	Absent    bool                   // no message is expected within the timeout (see Timeouts.Absent)
 }
 
 type Disconnect struct {
@@ -73,12 +100,15 @@ type Disconnect struct {
 // it allows for resource-driven scenario testing
 // disconnect reason errors are written in session.Errs
 // (correcponding to session.Peers)
+// t is only used by the Test methods, it can be nil if only the error
+// returning variants are used (e.g., RunExchanges instead of TestExchanges)
 func NewExchangeTestSession(t *testing.T, n TestNetAdapter, m TestMessenger, ids []*adapters.NodeId) *ExchangeTestSession {
 	return &ExchangeTestSession{
 		Ids:            ids,
 		TestNetAdapter: n,
 		TestMessenger:  m,
 		t:              t,
+		inboxes:        make(map[p2p.MsgReadWriter]*inbox),
 	}
 }
 
@@ -88,17 +118,37 @@ type TestPeerInfo struct {
 	Errc   chan error
 }
 
+// ExchangeResult reports the outcome of an exchange, the durations of its
+// triggers and expects are measured from the start of the exchange
+type ExchangeResult struct {
+	Index    int
+	Triggers []*StepResult
+	Expects  []*StepResult
+	Duration time.Duration
+	Err      error
+}
+
+// StepResult reports the outcome of a trigger or expect, Done is false if
+// the exchange failed before it was decided
+type StepResult struct {
+	Peer     *adapters.NodeId
+	Code     uint64
+	Done     bool
+	Duration time.Duration
+	Err      error
+}
+
 // trigger sends messages from peers
 func (self *ExchangeTestSession) trigger(trig Trigger) error {
 	peer := self.GetPeer(trig.Peer)
 	if peer == nil {
-		panic(fmt.Sprintf("trigger: peer %v does not exist (1- %v)", trig.Peer, len(self.Ids)))
+		return fmt.Errorf("trigger: peer %v does not exist (1- %v)", trig.Peer, len(self.Ids))
 	}
 	rw := peer.RW
 	if rw == nil {
 		return fmt.Errorf("trigger: peer %v unreachable", trig.Peer)
 	}
-	errc := make(chan error)
+	errc := make(chan error, 1)
 
 	go func() {
 		glog.V(6).Infof("trigger....")
@@ -106,11 +156,9 @@ func (self *ExchangeTestSession) trigger(trig Trigger) error {
 		glog.V(6).Infof("triggered")
 	}()
 
-	t := trig.Timeout
-	if t == time.Duration(0) {
-		t = 1000 * time.Millisecond
-	}
+	t := or(trig.Timeout, or(self.Timeouts.Trigger, 1000*time.Millisecond))
 	alarm := time.NewTimer(t)
+	defer alarm.Stop()
 	select {
 	case err := <-errc:
 		return err
@@ -125,100 +173,131 @@ func Key(id []byte) string {
 
 // expect checks an expectation
 func (self *ExchangeTestSession) expect(exp Expect) error {
-	if exp.Msg == nil {
-		panic("no message to expect")
+	if exp.Msg == nil && !exp.Absent && len(exp.Unordered) == 0 {
+		return fmt.Errorf("expect: no message to expect from peer %v", exp.Peer)
 	}
 	peer := self.GetPeer(exp.Peer)
 	if peer == nil {
-		panic(fmt.Sprintf("expect: peer %v does not exist (1- %v)", exp.Peer, len(self.Ids)))
+		return fmt.Errorf("expect: peer %v does not exist (1- %v)", exp.Peer, len(self.Ids))
 	}
 	rw := peer.RW
 	if rw == nil {
 		return fmt.Errorf("trigger: peer %v unreachable", exp.Peer)
 	}
-
-	errc := make(chan error)
-	go func() {
-		glog.V(6).Infof("waiting for msg, %v", exp.Msg)
-		errc <- self.ExpectMsg(rw, exp.Code, exp.Msg)
-	}()
-
-	t := exp.Timeout
-	if t == time.Duration(0) {
-		t = 1000 * time.Millisecond
-	}
-	alarm := time.NewTimer(t)
-	select {
-	case err := <-errc:
-		glog.V(6).Infof("expected msg arrives with error %v", err)
-		return err
-	case <-alarm.C:
-		glog.V(6).Infof("caught timeout")
-		return fmt.Errorf("timout expecting %v sent to peer %v", exp.Msg, exp.Peer)
-	}
-	// fatal upon encountering first exchange error
+	// all expectations read the messages of a peer through its inbox so that
+	// mismatches show the differences of the payloads (see ExpectedMsg) and
+	// expectations of the peer in the same exchange do not read concurrently
+	return self.expectInbox(self.inbox(rw), exp)
 }
 
 // TestExchange tests a series of exchanges againsts the session
+// the test fails upon the first exchange error (see RunExchanges)
 func (self *ExchangeTestSession) TestExchanges(exchanges ...Exchange) {
-	// launch all triggers of this exchanges
+	if _, err := self.RunExchanges(exchanges...); err != nil {
+		self.t.Fatalf("%v", err)
+	}
+}
 
+// RunExchanges runs the exchanges in order and returns their results up to
+// and including the first exchange that fails, whose error is returned
+func (self *ExchangeTestSession) RunExchanges(exchanges ...Exchange) ([]*ExchangeResult, error) {
+	var results []*ExchangeResult
 	for i, e := range exchanges {
-		errc := make(chan error)
-		wg := &sync.WaitGroup{}
-		for _, trig := range e.Triggers {
-			wg.Add(1)
-			// separate go routing to allow parallel requests
-			go func(t Trigger) {
-				defer wg.Done()
-				err := self.trigger(t)
-				if err != nil {
-					errc <- err
-				}
-			}(trig)
-		}
-
-		// each expectation is spawned in separate go-routine
-		// expectations of an exchange are conjunctive but uordered, i.e., only all of them arriving constitutes a pass
-		// each expectation is meant to be for a different peer, otherwise they are expected to panic
-		// testing of an exchange blocks until all expectations are decided
-		// an expectation is decided if
-		//  expected message arrives OR
-		// an unexpected message arrives (panic)
-		// times out on their individual tiemeout
-		for _, ex := range e.Expects {
-			wg.Add(1)
-			// expect msg spawned to separate go routine
-			go func(exp Expect) {
-				defer wg.Done()
-				err := self.expect(exp)
-				if err != nil {
-					glog.V(6).Infof("expect msg fails %v", err)
-					errc <- err
-				}
-			}(ex)
+		result := self.runExchange(e)
+		result.Index = i
+		results = append(results, result)
+		if result.Err != nil {
+			return results, result.Err
 		}
+		glog.V(6).Infof("exchange %v run successfully in %v", i, result.Duration)
+	}
+	return results, nil
+}
 
-		// wait for all expectations
-		go func() {
-			wg.Wait()
-			close(errc)
-		}()
+type stepDone struct {
+	step *StepResult
+	err  error
+	at   time.Duration
+}
 
-		// time out globally or finish when all expectations satisfied
-		alarm := time.NewTimer(1000 * time.Millisecond)
-		select {
+// runExchange launches all triggers and expectations of the exchange
+func (self *ExchangeTestSession) runExchange(e Exchange) *ExchangeResult {
+	result := &ExchangeResult{}
+	start := time.Now()
+	// buffered so that steps finishing after a failure do not block
+	donec := make(chan *stepDone, len(e.Triggers)+len(e.Expects))
+	for _, trig := range e.Triggers {
+		step := &StepResult{Peer: trig.Peer, Code: trig.Code}
+		result.Triggers = append(result.Triggers, step)
+		// separate go routing to allow parallel requests
+		go func(t Trigger) {
+			err := self.trigger(t)
+			donec <- &stepDone{step, err, time.Since(start)}
+		}(trig)
+	}
 
-		case err := <-errc:
+	// each expectation is spawned in separate go-routine
+	// expectations of an exchange are conjunctive but uordered, i.e., only all of them arriving constitutes a pass
+	// each expectation is meant to be for a different peer, otherwise they are expected to panic
+	// testing of an exchange blocks until all expectations are decided
+	// an expectation is decided if
+	//  expected message arrives OR
+	// an unexpected message arrives (panic)
+	// times out on their individual tiemeout
+	for _, ex := range e.Expects {
+		step := &StepResult{Peer: ex.Peer, Code: ex.Code}
+		result.Expects = append(result.Expects, step)
+		// expect msg spawned to separate go routine
+		go func(exp Expect) {
+			err := self.expect(exp)
 			if err != nil {
-				self.t.Fatalf("exchange failed with: %v", err)
-			} else {
-				glog.V(6).Infof("exchange %v run successfully", i)
+				glog.V(6).Infof("expect msg fails %v", err)
+			}
+			donec <- &stepDone{step, err, time.Since(start)}
+		}(ex)
+	}
+
+	// time out globally or finish when all expectations satisfied
+	alarm := time.NewTimer(self.exchangeTimeout(e))
+	defer alarm.Stop()
+	// results are only updated here so that they are not written after return
+	for n := len(e.Triggers) + len(e.Expects); n > 0 && result.Err == nil; n-- {
+		select {
+		case d := <-donec:
+			d.step.Done = true
+			d.step.Duration = d.at
+			d.step.Err = d.err
+			if d.err != nil {
+				result.Err = fmt.Errorf("exchange failed with: %v", d.err)
 			}
 		case <-alarm.C:
-			self.t.Fatalf("exchange timed out")
+			result.Err = fmt.Errorf("exchange timed out")
 		}
 	}
+	result.Duration = time.Since(start)
+	return result
+}
+
+// exchangeTimeout returns the global timeout of the exchange, which allows for
+// the longest timeout of its triggers and expectations
+func (self *ExchangeTestSession) exchangeTimeout(e Exchange) time.Duration {
+	timeout := or(self.Timeouts.Exchange, 1000*time.Millisecond)
+	extend := func(t time.Duration) {
+		if t >= timeout {
+			timeout = t + 100*time.Millisecond
+		}
+	}
+	for _, trig := range e.Triggers {
+		extend(or(trig.Timeout, self.Timeouts.Trigger))
+	}
+	for _, exp := range e.Expects {
+		if exp.Absent {
+			extend(or(exp.Timeout, self.Timeouts.Absent))
+		} else {
+			extend(or(exp.Timeout, self.Timeouts.Expect))
+		}
+	}
+	return timeout
 }
 
 type flushMsg struct{}
@@ -240,62 +319,95 @@ func flushExchange(c int, ids ...*adapters.NodeId) Exchange {
 
 var FlushMsg = &flushMsg{}
 
+// TestConnected fails the test unless the peers connect (see CheckConnected)
 func (self *ExchangeTestSession) TestConnected(flush bool, peers ...*adapters.NodeId) {
-	timeout := time.NewTimer(1000 * time.Millisecond)
-	var flushc chan bool
-	if !flush {
-		flushc = make(chan bool)
-		close(flushc)
+	if err := self.CheckConnected(flush, peers...); err != nil {
+		self.t.Fatalf("%v", err)
 	}
-	wg := &sync.WaitGroup{}
-	wg.Add(len(peers))
+}
+
+// CheckConnected waits for the peers to connect, and to flush if flush is
+// true, it returns the first error if not all of them do within the timeout
+func (self *ExchangeTestSession) CheckConnected(flush bool, peers ...*adapters.NodeId) error {
+	timeoutc := make(chan struct{})
+	alarm := time.AfterFunc(or(self.Timeouts.Connect, 1000*time.Millisecond), func() { close(timeoutc) })
+	defer alarm.Stop()
+	closed := make(chan bool)
+	close(closed)
+	errc := make(chan error, len(peers))
 	for _, id := range peers {
-		ticker := time.NewTicker(100 * time.Millisecond)
 		go func(p *adapters.NodeId) {
-			defer wg.Done()
+			ticker := time.NewTicker(100 * time.Millisecond)
+			defer ticker.Stop()
 			for {
 				peer := self.GetPeer(p)
 				if peer != nil {
+					flushc := closed
 					if flush {
 						flushc = peer.Flushc
 					}
 					select {
-					case <-timeout.C:
-						self.t.Fatalf("exchange timed out waiting for peer %v to flush", p)
+					case <-timeoutc:
+						errc <- fmt.Errorf("exchange timed out waiting for peer %v to flush", p)
+						return
 					case err := <-peer.Errc:
-						self.t.Fatalf("peer %v disconnected with error %v", p, err)
+						errc <- fmt.Errorf("peer %v disconnected with error %v", p, err)
+						return
 					case <-flushc:
 						glog.V(6).Infof("peer %v is connected", p)
+						errc <- nil
 						return
 					}
 				}
 				select {
 				case <-ticker.C:
 					glog.V(6).Infof("waiting for %v to connect", p)
-				case <-timeout.C:
-					self.t.Fatalf("exchange timed out waiting for peer %v to connect", p)
+				case <-timeoutc:
+					errc <- fmt.Errorf("exchange timed out waiting for peer %v to connect", p)
+					return
 				}
 			}
 		}(id)
 	}
-	wg.Wait()
+	var err error
+	for range peers {
+		if e := <-errc; e != nil && err == nil {
+			err = e
+		}
+	}
 	glog.V(6).Infof("checking complete")
-
+	return err
 }
 
+// TestDisconnected fails the test unless the peers disconnect with the
+// given errors (see CheckDisconnected)
 func (self *ExchangeTestSession) TestDisconnected(disconnects ...*Disconnect) {
+	if err := self.CheckDisconnected(disconnects...); err != nil {
+		self.t.Fatalf("%v", err)
+	}
+}
+
+// CheckDisconnected waits for each peer in turn to disconnect with the given
+// error, it returns the first mismatch or timeout
+func (self *ExchangeTestSession) CheckDisconnected(disconnects ...*Disconnect) error {
 	for _, disconnect := range disconnects {
 		id := disconnect.Peer
 		err := disconnect.Error
-		errc := self.GetPeer(id).Errc
-		alarm := time.NewTimer(1000 * time.Millisecond)
+		peer := self.GetPeer(id)
+		if peer == nil || peer.Errc == nil {
+			return fmt.Errorf("peer %v does not exist", id)
+		}
+		errc := peer.Errc
+		alarm := time.NewTimer(or(self.Timeouts.Disconnect, 1000*time.Millisecond))
 		select {
 		case derr := <-errc:
+			alarm.Stop()
 			if !((err == nil && derr == nil) || err != nil && derr != nil && err.Error() == derr.Error()) {
-				self.t.Fatalf("unexpected error on peer %v: '%v', wanted '%v'", id, derr, err)
+				return fmt.Errorf("unexpected error on peer %v: '%v', wanted '%v'", id, derr, err)
 			}
 		case <-alarm.C:
-			self.t.Fatalf("exchange timed out waiting for peer %v to disconnect", id)
+			return fmt.Errorf("exchange timed out waiting for peer %v to disconnect", id)
 		}
 	}
+	return nil
 }
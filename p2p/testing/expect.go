@@ -0,0 +1,225 @@
+package testing
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// ExpectedMsg is one of the messages of an unordered expectation
+// the message is accepted if Match accepts it decoded into the type of Msg,
+// otherwise if its payload equals Msg, any message of the code is accepted if
+// Msg is nil
+type ExpectedMsg struct {
+	Msg   interface{}
+	Code  uint64
+	Match func(interface{}) bool
+}
+
+func (self *ExpectedMsg) String() string {
+	if self.Msg == nil {
+		return fmt.Sprintf("any message (code %v)", self.Code)
+	}
+	return fmt.Sprintf("%+v (code %v)", self.Msg, self.Code)
+}
+
+// received is a message read by an inbox
+type received struct {
+	code    uint64
+	payload []byte
+}
+
+// inbox reads the messages of a peer connection in the background so that
+// expectations can wait for messages with a timeout without losing the
+// messages arriving after the timeout
+type inbox struct {
+	msgc chan *received
+	err  error // reason the connection closed, set before msgc is closed
+}
+
+func newInbox(r p2p.MsgReader) *inbox {
+	self := &inbox{msgc: make(chan *received)}
+	go func() {
+		for {
+			msg, err := r.ReadMsg()
+			if err != nil {
+				self.err = err
+				close(self.msgc)
+				return
+			}
+			payload, err := ioutil.ReadAll(msg.Payload)
+			msg.Discard()
+			if err != nil {
+				self.err = err
+				close(self.msgc)
+				return
+			}
+			self.msgc <- &received{msg.Code, payload}
+		}
+	}()
+	return self
+}
+
+func (self *ExchangeTestSession) inbox(rw p2p.MsgReadWriter) *inbox {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	in, ok := self.inboxes[rw]
+	if !ok {
+		in = newInbox(rw)
+		self.inboxes[rw] = in
+	}
+	return in
+}
+
+// expectInbox checks an expectation on the messages read by the inbox
+func (self *ExchangeTestSession) expectInbox(in *inbox, exp Expect) error {
+	t := or(exp.Timeout, or(self.Timeouts.Expect, 1000*time.Millisecond))
+	if exp.Absent {
+		t = or(exp.Timeout, or(self.Timeouts.Absent, 100*time.Millisecond))
+	}
+	alarm := time.NewTimer(t)
+	defer alarm.Stop()
+
+	if exp.Absent {
+		select {
+		case r, ok := <-in.msgc:
+			if !ok {
+				return nil
+			}
+			return fmt.Errorf("expected no message on peer %v, got message code %v: %v", exp.Peer, r.code, describe(r, exp.Msg))
+		case <-alarm.C:
+			return nil
+		}
+	}
+
+	pending := exp.Unordered
+	if len(pending) == 0 {
+		pending = []*ExpectedMsg{{Msg: exp.Msg, Code: exp.Code, Match: exp.Match}}
+	}
+	pending = append([]*ExpectedMsg{}, pending...)
+	for len(pending) > 0 {
+		select {
+		case r, ok := <-in.msgc:
+			if !ok {
+				return fmt.Errorf("peer %v disconnected (%v) while expecting %v", exp.Peer, in.err, pending)
+			}
+			i, err := match(pending, r)
+			if err != nil {
+				return fmt.Errorf("peer %v: %v", exp.Peer, err)
+			}
+			glog.V(6).Infof("peer %v received expected %v", exp.Peer, pending[i])
+			pending = append(pending[:i], pending[i+1:]...)
+		case <-alarm.C:
+			return fmt.Errorf("timout expecting %v sent to peer %v", pending, exp.Peer)
+		}
+	}
+	return nil
+}
+
+// match returns the index of the first pending message accepting r or an
+// error showing how r differs from the pending messages of the same code
+func match(pending []*ExpectedMsg, r *received) (int, error) {
+	var diffs []string
+	var codes []uint64
+	for i, exp := range pending {
+		codes = append(codes, exp.Code)
+		if exp.Code != r.code {
+			continue
+		}
+		err := exp.check(r.payload)
+		if err == nil {
+			return i, nil
+		}
+		diffs = append(diffs, err.Error())
+	}
+	if len(diffs) == 0 {
+		return 0, fmt.Errorf("message code mismatch: got %v, expected one of %v", r.code, codes)
+	}
+	return 0, fmt.Errorf("message (code %v) does not match any expected:\n%v", r.code, strings.Join(diffs, "\n"))
+}
+
+// check returns an error describing the difference if the payload does not
+// satisfy the expected message
+func (self *ExpectedMsg) check(payload []byte) error {
+	if self.Msg == nil {
+		if self.Match != nil {
+			return fmt.Errorf("cannot match message without type (Msg is nil)")
+		}
+		return nil
+	}
+	if self.Match != nil {
+		got, err := decode(payload, self.Msg)
+		if err != nil {
+			return fmt.Errorf("cannot decode payload %x as %T: %v", payload, self.Msg, err)
+		}
+		if !self.Match(got) {
+			return fmt.Errorf("message rejected by predicate: got %+v", got)
+		}
+		return nil
+	}
+	want, err := rlp.EncodeToBytes(self.Msg)
+	if err != nil {
+		panic("content encode error: " + err.Error())
+	}
+	if bytes.Equal(want, payload) {
+		return nil
+	}
+	got, err := decode(payload, self.Msg)
+	if err != nil {
+		return fmt.Errorf("payload mismatch:\n  expected: %+v\n  got undecodable payload %x (%v)", self.Msg, payload, err)
+	}
+	return fmt.Errorf("payload mismatch:\n  expected: %+v\n  got:      %+v%v", self.Msg, got, diff(self.Msg, got))
+}
+
+// decode decodes the payload into a new value of the type of msg
+func decode(payload []byte, msg interface{}) (interface{}, error) {
+	typ := reflect.TypeOf(msg)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	v := reflect.New(typ)
+	if err := rlp.DecodeBytes(payload, v.Interface()); err != nil {
+		return nil, err
+	}
+	if reflect.TypeOf(msg).Kind() != reflect.Ptr {
+		return v.Elem().Interface(), nil
+	}
+	return v.Interface(), nil
+}
+
+// describe decodes the payload into the type of msg if given for display
+func describe(r *received, msg interface{}) string {
+	if msg != nil {
+		if got, err := decode(r.payload, msg); err == nil {
+			return fmt.Sprintf("%+v", got)
+		}
+	}
+	return fmt.Sprintf("%x", r.payload)
+}
+
+// diff lists the fields of the structs that differ
+func diff(want, got interface{}) string {
+	w := reflect.Indirect(reflect.ValueOf(want))
+	g := reflect.Indirect(reflect.ValueOf(got))
+	if w.Kind() != reflect.Struct || w.Type() != g.Type() {
+		return ""
+	}
+	var lines []string
+	for i := 0; i < w.NumField(); i++ {
+		if !w.Field(i).CanInterface() {
+			continue
+		}
+		wf, gf := w.Field(i).Interface(), g.Field(i).Interface()
+		if !reflect.DeepEqual(wf, gf) {
+			lines = append(lines, fmt.Sprintf("\n  %v: expected %+v, got %+v", w.Type().Field(i).Name, wf, gf))
+		}
+	}
+	return strings.Join(lines, "")
+}
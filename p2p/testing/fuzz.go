@@ -0,0 +1,249 @@
+package testing
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/adapters"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// FuzzCodes lists the message types of the protocol under fuzzing by code,
+// it is implemented by protocols.CodeMap
+type FuzzCodes interface {
+	MsgTypes
+	Length() uint64
+	TypeName(code uint64) string
+}
+
+// FuzzInput is a message sent to the protocol under fuzzing
+type FuzzInput struct {
+	Code    uint64
+	Payload []byte
+}
+
+// MsgFuzzer feeds random and mutated payloads of the message codes of a
+// protocol, out of range codes and oversize messages to the protocol run
+// function over a p2p.MsgPipe
+// the protocol passes if it handles the message or returns an error accepted
+// by IsProtocolError, it fails if it panics, hangs or returns any other error
+type MsgFuzzer struct {
+	Codes FuzzCodes
+	Run   adapters.ProtoCall
+	// max message size of the protocol, an oversize message is generated if set
+	MaxMsgSize int
+	// run on the remote end of the pipe before the fuzzed message is sent,
+	// e.g., to complete handshakes
+	Setup func(rw p2p.MsgReadWriter) error
+	// accepts the errors the protocol may disconnect with, any error is
+	// accepted if nil
+	IsProtocolError func(error) bool
+	// time allowed for the setup, handling the message and returning after
+	// the pipe is closed, defaults to one second
+	Timeout time.Duration
+}
+
+// Corpus returns inputs generated with r, for each code the encoded zero
+// value, n random values and n mutations of them, plus messages with out of
+// range codes and an oversize message
+func (self *MsgFuzzer) Corpus(r *rand.Rand, n int) []*FuzzInput {
+	var inputs []*FuzzInput
+	length := self.Codes.Length()
+	for code := uint64(0); code < length; code++ {
+		_, msg, err := self.Codes.NewMsg(self.Codes.TypeName(code))
+		if err != nil {
+			panic(err.Error())
+		}
+		zero, err := rlp.EncodeToBytes(msg)
+		if err == nil {
+			inputs = append(inputs, &FuzzInput{code, zero})
+		}
+		for i := 0; i < n; i++ {
+			v := reflect.New(reflect.Indirect(reflect.ValueOf(msg)).Type())
+			randomFill(r, v.Elem(), 0)
+			payload, err := rlp.EncodeToBytes(v.Interface())
+			if err != nil {
+				glog.V(6).Infof("cannot encode random %v: %v", v.Type(), err)
+				continue
+			}
+			inputs = append(inputs, &FuzzInput{code, payload}, &FuzzInput{code, mutate(r, payload)})
+		}
+	}
+	inputs = append(inputs, &FuzzInput{length, []byte{0xc0}}, &FuzzInput{length + uint64(r.Intn(1<<16)), randomBytes(r, 16)})
+	if self.MaxMsgSize > 0 {
+		payload, _ := rlp.EncodeToBytes(randomBytes(r, self.MaxMsgSize+1))
+		inputs = append(inputs, &FuzzInput{uint64(r.Int63n(int64(length) + 1)), payload})
+	}
+	return inputs
+}
+
+// Fuzz seeds f with a corpus generated from seed and fuzzes the protocol
+// with the inputs of go native fuzzing
+func (self *MsgFuzzer) Fuzz(f *testing.F, seed int64, n int) {
+	for _, in := range self.Corpus(rand.New(rand.NewSource(seed)), n) {
+		f.Add(in.Code, in.Payload)
+	}
+	f.Fuzz(func(t *testing.T, code uint64, payload []byte) {
+		if err := self.Check(code, payload); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// Check runs the protocol on a new pipe, sends it the message and closes the
+// pipe, it returns an error if the protocol panics, hangs or returns an error
+// not accepted by IsProtocolError
+func (self *MsgFuzzer) Check(code uint64, payload []byte) error {
+	timeout := or(self.Timeout, 1000*time.Millisecond)
+	id := RandomNodeId()
+	rw, prw := p2p.MsgPipe()
+	defer rw.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errc <- &panicError{r}
+			}
+		}()
+		errc <- self.Run(p2p.NewPeer(id.NodeID, id.Label(), nil), prw)
+	}()
+
+	donec := make(chan error, 1)
+	go func() {
+		if self.Setup != nil {
+			if err := self.Setup(rw); err != nil {
+				donec <- fmt.Errorf("setup failed: %v", err)
+				return
+			}
+		}
+		// read the responses so that the protocol does not block sending
+		go func() {
+			for {
+				msg, err := rw.ReadMsg()
+				if err != nil {
+					return
+				}
+				msg.Discard()
+			}
+		}()
+		donec <- rw.WriteMsg(p2p.Msg{Code: code, Size: uint32(len(payload)), Payload: bytes.NewReader(payload)})
+	}()
+
+	alarm := time.NewTimer(timeout)
+	defer alarm.Stop()
+	select {
+	case err := <-donec:
+		if err != nil && err != p2p.ErrPipeClosed {
+			return err
+		}
+	case err := <-errc:
+		return self.check(code, err)
+	case <-alarm.C:
+		return fmt.Errorf("protocol hangs on message (code %v): %x", code, payload)
+	}
+	rw.Close()
+	select {
+	case err := <-errc:
+		return self.check(code, err)
+	case <-alarm.C:
+		return fmt.Errorf("protocol hangs after message (code %v): %x", code, payload)
+	}
+}
+
+// panicError is returned by the protocol run function that panicked
+type panicError struct {
+	v interface{}
+}
+
+func (self *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", self.v)
+}
+
+// check returns an error unless the protocol returned after the pipe was
+// closed or with a protocol error
+func (self *MsgFuzzer) check(code uint64, err error) error {
+	if err == nil || err == p2p.ErrPipeClosed {
+		return nil
+	}
+	if _, ok := err.(*panicError); ok {
+		return fmt.Errorf("protocol panics on message (code %v): %v", code, err)
+	}
+	if self.IsProtocolError != nil && !self.IsProtocolError(err) {
+		return fmt.Errorf("unexpected error on message (code %v): %v (%T)", code, err, err)
+	}
+	glog.V(6).Infof("protocol disconnects on message (code %v): %v", code, err)
+	return nil
+}
+
+// randomFill sets the exported fields of v to random values, nested values
+// down to a limited depth
+func randomFill(r *rand.Rand, v reflect.Value, depth int) {
+	if depth > 4 || !v.CanSet() {
+		return
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(r.Intn(2) == 1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(r.Int63()) >> uint(r.Intn(64)))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(r.Int63() >> uint(r.Intn(64)))
+	case reflect.String:
+		v.SetString(string(randomBytes(r, r.Intn(32))))
+	case reflect.Slice:
+		s := reflect.MakeSlice(v.Type(), r.Intn(8), r.Intn(8)+8)
+		for i := 0; i < s.Len(); i++ {
+			randomFill(r, s.Index(i), depth+1)
+		}
+		v.Set(s)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			randomFill(r, v.Index(i), depth+1)
+		}
+	case reflect.Ptr:
+		if r.Intn(4) == 0 {
+			return
+		}
+		p := reflect.New(v.Type().Elem())
+		randomFill(r, p.Elem(), depth+1)
+		v.Set(p)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			randomFill(r, v.Field(i), depth+1)
+		}
+	}
+}
+
+// mutate returns a copy of the payload with random bytes changed, removed
+// or appended
+func mutate(r *rand.Rand, payload []byte) []byte {
+	b := append([]byte{}, payload...)
+	switch r.Intn(4) {
+	case 0:
+		if len(b) > 0 {
+			b[r.Intn(len(b))] ^= byte(1 + r.Intn(255))
+		}
+	case 1:
+		if len(b) > 0 {
+			b = b[:r.Intn(len(b))]
+		}
+	case 2:
+		b = append(b, randomBytes(r, 1+r.Intn(8))...)
+	default:
+		b = randomBytes(r, len(b))
+	}
+	return b
+}
+
+func randomBytes(r *rand.Rand, n int) []byte {
+	b := make([]byte, n)
+	r.Read(b)
+	return b
+}
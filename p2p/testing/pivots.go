@@ -0,0 +1,120 @@
+package testing
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/p2p/adapters"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+)
+
+// MultiPivotSession is an exchange session over a network in which the
+// protocol under test runs on several pivot nodes, the other nodes are
+// passive endpoints (edge nodes) connected to a single pivot each
+// session.Ids lists all nodes by index, triggers and expects refer to edge
+// nodes and act on their connection to their pivot, so messages injected at
+// one edge node can be expected at edge nodes several hops away
+type MultiPivotSession struct {
+	*ExchangeTestSession
+	Network *simulations.Network
+	Pivots  []*adapters.NodeId
+	// pivot of each edge node
+	pivots map[discover.NodeID]*adapters.NodeId
+}
+
+// NewMultiPivotTester creates a network of n nodes, runs the protocol on the
+// nodes with the given indexes and connects the nodes along the edges (pairs
+// of node indexes, the first one dials), the edges can be generated by
+// p2p/simulations/topology
+// it errors if edge nodes are connected to each other or to more than one pivot
+// t is only needed by the Test methods of the session (see NewExchangeTestSession)
+// the session must be stopped to shut down the network (see Stop)
+func NewMultiPivotTester(t *testing.T, n int, pivots []int, edges [][2]int, run func(adapters.NodeAdapter) adapters.ProtoCall) (*MultiPivotSession, error) {
+	ids := RandomNodeIds(n)
+	isPivot := make(map[discover.NodeID]bool)
+	self := &MultiPivotSession{pivots: make(map[discover.NodeID]*adapters.NodeId)}
+	for _, i := range pivots {
+		if i < 0 || i >= n {
+			return nil, fmt.Errorf("pivot %v does not exist (%v nodes)", i, n)
+		}
+		isPivot[ids[i].NodeID] = true
+		self.Pivots = append(self.Pivots, ids[i])
+	}
+	for _, e := range edges {
+		if e[0] < 0 || e[0] >= n || e[1] < 0 || e[1] >= n {
+			return nil, fmt.Errorf("edge %v: node does not exist (%v nodes)", e, n)
+		}
+		one, other := ids[e[0]], ids[e[1]]
+		switch {
+		case isPivot[one.NodeID] && isPivot[other.NodeID]:
+			continue
+		case isPivot[one.NodeID]:
+			one, other = other, one
+		case !isPivot[other.NodeID]:
+			return nil, fmt.Errorf("edge %v connects two edge nodes", e)
+		}
+		if pivot, found := self.pivots[one.NodeID]; found && pivot.NodeID != other.NodeID {
+			return nil, fmt.Errorf("edge node %v is connected to more than one pivot", e[0])
+		}
+		self.pivots[one.NodeID] = other
+	}
+
+	simPipe := &adapters.SimPipe{}
+	network := simulations.NewNetwork(nil, nil)
+	network.SetNaf(func(conf *simulations.NodeConfig) adapters.NodeAdapter {
+		na := adapters.NewSimNode(conf.Id, network, simPipe)
+		if isPivot[conf.Id.NodeID] {
+			na.Run = run(na)
+		}
+		return na
+	})
+	if err := buildPivotNetwork(network, ids, edges); err != nil {
+		network.Shutdown()
+		return nil, err
+	}
+	glog.V(6).Infof("multi pivot network: %v nodes, %v pivots, %v edges", n, len(pivots), len(edges))
+	self.Network = network
+	self.ExchangeTestSession = NewExchangeTestSession(t, self, simPipe, ids)
+	return self, nil
+}
+
+func buildPivotNetwork(network *simulations.Network, ids []*adapters.NodeId, edges [][2]int) error {
+	for _, id := range ids {
+		if err := network.NewNode(&simulations.NodeConfig{Id: id}); err != nil {
+			return err
+		}
+		if err := network.Start(id); err != nil {
+			return err
+		}
+	}
+	for _, e := range edges {
+		if err := network.Connect(ids[e[0]], ids[e[1]]); err != nil {
+			return fmt.Errorf("cannot connect %v to %v: %v", ids[e[0]], ids[e[1]], err)
+		}
+	}
+	return nil
+}
+
+// Stop shuts down the network of the session, the protocols on the pivots
+// return as their connections are dropped
+func (self *MultiPivotSession) Stop() error {
+	return self.Network.Shutdown()
+}
+
+// GetPeer returns the connection of an edge node to its pivot, nil if the
+// node is not an edge node
+func (self *MultiPivotSession) GetPeer(id *adapters.NodeId) *adapters.Peer {
+	pivot, ok := self.pivots[id.NodeID]
+	if !ok {
+		return nil
+	}
+	return self.Network.GetNodeAdapter(pivot).(TestNetAdapter).GetPeer(id)
+}
+
+// Pivot returns the pivot the edge node is connected to, nil if the node is
+// not an edge node
+func (self *MultiPivotSession) Pivot(id *adapters.NodeId) *adapters.NodeId {
+	return self.pivots[id.NodeID]
+}
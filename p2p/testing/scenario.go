@@ -0,0 +1,364 @@
+package testing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/p2p/adapters"
+)
+
+// Scenario is a protocol exchange test written as a file (see LoadScenario)
+// peers are referred to by their index in the session (session.Ids)
+// message fields can refer to the id of peer n as "$peer<n>"
+//
+//	{
+//	  "name": "handshake",
+//	  "peers": 1,
+//	  "exchanges": [
+//	    {"expects": [{"type": "protoHandshake", "fields": {"Version": 42, "NetworkId": "420"}, "peer": 0}]},
+//	    {"triggers": [{"type": "protoHandshake", "fields": {"Version": 41, "NetworkId": "420"}, "peer": 0, "timeout": "500ms"}]}
+//	  ],
+//	  "disconnects": [{"peer": 0, "error": "41 (!= 42)"}]
+//	}
+type Scenario struct {
+	Name        string                `json:"name"`
+	Peers       int                   `json:"peers"` // number of peers of the session, defaults to the peers referred to
+	Exchanges   []*ScenarioExchange   `json:"exchanges"`
+	Disconnects []*ScenarioDisconnect `json:"disconnects"`
+}
+
+// ScenarioExchange is an Exchange of a scenario
+type ScenarioExchange struct {
+	Triggers []*ScenarioMsg `json:"triggers"`
+	Expects  []*ScenarioMsg `json:"expects"`
+}
+
+// ScenarioMsg is a Trigger or Expect of a scenario, Type is the name of the
+// message type in the protocol (see MsgTypes)
+// expects can instead list messages expected in any order (the peer and
+// timeout of the listed messages are ignored) or expect no message (see Expect)
+type ScenarioMsg struct {
+	Type      string          `json:"type"`
+	Fields    json.RawMessage `json:"fields"`
+	Peer      int             `json:"peer"`
+	Timeout   Duration        `json:"timeout"`
+	Unordered []*ScenarioMsg  `json:"unordered"`
+	Absent    bool            `json:"absent"`
+}
+
+// ScenarioDisconnect is the Disconnect expected of a peer at the end of the
+// scenario, Error is the disconnect reason, empty if none
+type ScenarioDisconnect struct {
+	Peer  int    `json:"peer"`
+	Error string `json:"error"`
+}
+
+// Duration is a time.Duration given either as a string (e.g., "500ms") or in
+// nanoseconds
+type Duration time.Duration
+
+func (self Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(self).String())
+}
+
+func (self *Duration) UnmarshalJSON(value []byte) error {
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		var n int64
+		if err := json.Unmarshal(value, &n); err != nil {
+			return fmt.Errorf("invalid duration %s", value)
+		}
+		*self = Duration(n)
+		return nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*self = Duration(d)
+	return nil
+}
+
+// MsgTypes resolves the message type names of scenarios to message codes and
+// new messages to decode the fields into, it is implemented by protocols.CodeMap
+type MsgTypes interface {
+	NewMsg(name string) (uint64, interface{}, error)
+}
+
+// ScenarioDecoders convert scenario files to JSON by file extension
+// JSON files are read as they are, other formats can be added with
+// RegisterScenarioDecoder, e.g., YAML with github.com/ghodss/yaml.YAMLToJSON
+var ScenarioDecoders = map[string]func([]byte) ([]byte, error){
+	".json": func(b []byte) ([]byte, error) { return b, nil },
+}
+
+// RegisterScenarioDecoder makes scenario files with the given extension loadable
+func RegisterScenarioDecoder(ext string, f func([]byte) ([]byte, error)) {
+	ScenarioDecoders[ext] = f
+}
+
+// LoadScenario reads a scenario file, the name of the scenario defaults to the
+// file name without extension
+func LoadScenario(path string) (*Scenario, error) {
+	ext := filepath.Ext(path)
+	decode, ok := ScenarioDecoders[ext]
+	if !ok {
+		return nil, fmt.Errorf("unknown scenario format '%v'", ext)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err = decode(b)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode scenario %v: %v", path, err)
+	}
+	sc := &Scenario{}
+	if err := json.Unmarshal(b, sc); err != nil {
+		return nil, fmt.Errorf("invalid scenario %v: %v", path, err)
+	}
+	if len(sc.Name) == 0 {
+		sc.Name = strings.TrimSuffix(filepath.Base(path), ext)
+	}
+	return sc, nil
+}
+
+// LoadScenarios reads the scenario files of the directory in file name order
+// files of unknown formats are skipped
+func LoadScenarios(dir string) ([]*Scenario, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var scenarios []*Scenario
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		if _, ok := ScenarioDecoders[filepath.Ext(f.Name())]; !ok {
+			continue
+		}
+		sc, err := LoadScenario(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		scenarios = append(scenarios, sc)
+	}
+	return scenarios, nil
+}
+
+// peerCount returns the number of peers the scenario needs
+func (self *Scenario) peerCount() int {
+	n := self.Peers
+	max := func(i int) {
+		if i+1 > n {
+			n = i + 1
+		}
+	}
+	for _, e := range self.Exchanges {
+		for _, m := range e.Triggers {
+			max(m.Peer)
+		}
+		for _, m := range e.Expects {
+			max(m.Peer)
+		}
+	}
+	for _, d := range self.Disconnects {
+		max(d.Peer)
+	}
+	return n
+}
+
+var peerRef = regexp.MustCompile(`"\$peer(\d+)"`)
+
+func (self *ScenarioMsg) peer(ids []*adapters.NodeId) (*adapters.NodeId, error) {
+	if self.Peer < 0 || self.Peer >= len(ids) {
+		return nil, fmt.Errorf("peer %v does not exist (%v peers)", self.Peer, len(ids))
+	}
+	return ids[self.Peer], nil
+}
+
+// msg resolves the message type and decodes the fields with peer references
+// replaced by the ids
+func (self *ScenarioMsg) msg(types MsgTypes, ids []*adapters.NodeId) (uint64, interface{}, error) {
+	code, msg, err := types.NewMsg(self.Type)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(self.Fields) > 0 {
+		var rerr error
+		fields := peerRef.ReplaceAllFunc(self.Fields, func(ref []byte) []byte {
+			i, _ := strconv.Atoi(string(peerRef.FindSubmatch(ref)[1]))
+			if i >= len(ids) {
+				rerr = fmt.Errorf("peer %v does not exist (%v peers)", i, len(ids))
+				return ref
+			}
+			return []byte(`"` + ids[i].String() + `"`)
+		})
+		if rerr != nil {
+			return 0, nil, rerr
+		}
+		if err := json.Unmarshal(fields, msg); err != nil {
+			return 0, nil, fmt.Errorf("invalid fields of %v: %v", self.Type, err)
+		}
+	}
+	return code, msg, nil
+}
+
+// expect returns the Expect of the scenario message
+func (self *ScenarioMsg) expect(types MsgTypes, ids []*adapters.NodeId) (Expect, error) {
+	id, err := self.peer(ids)
+	if err != nil {
+		return Expect{}, err
+	}
+	exp := Expect{Peer: id, Timeout: time.Duration(self.Timeout), Absent: self.Absent}
+	if self.Absent {
+		return exp, nil
+	}
+	if len(self.Unordered) == 0 {
+		exp.Code, exp.Msg, err = self.msg(types, ids)
+		return exp, err
+	}
+	for _, m := range self.Unordered {
+		code, msg, err := m.msg(types, ids)
+		if err != nil {
+			return Expect{}, err
+		}
+		exp.Unordered = append(exp.Unordered, &ExpectedMsg{Code: code, Msg: msg})
+	}
+	return exp, nil
+}
+
+// Build returns the exchanges and the expected disconnects of the scenario
+// for the peers of a session
+func (self *Scenario) Build(types MsgTypes, ids []*adapters.NodeId) ([]Exchange, []*Disconnect, error) {
+	var exchanges []Exchange
+	for i, e := range self.Exchanges {
+		var exchange Exchange
+		for _, m := range e.Triggers {
+			id, err := m.peer(ids)
+			if err != nil {
+				return nil, nil, fmt.Errorf("exchange %v: %v", i, err)
+			}
+			code, msg, err := m.msg(types, ids)
+			if err != nil {
+				return nil, nil, fmt.Errorf("exchange %v: %v", i, err)
+			}
+			exchange.Triggers = append(exchange.Triggers, Trigger{Code: code, Msg: msg, Peer: id, Timeout: time.Duration(m.Timeout)})
+		}
+		for _, m := range e.Expects {
+			exp, err := m.expect(types, ids)
+			if err != nil {
+				return nil, nil, fmt.Errorf("exchange %v: %v", i, err)
+			}
+			exchange.Expects = append(exchange.Expects, exp)
+		}
+		exchanges = append(exchanges, exchange)
+	}
+	var disconnects []*Disconnect
+	for _, d := range self.Disconnects {
+		if d.Peer < 0 || d.Peer >= len(ids) {
+			return nil, nil, fmt.Errorf("disconnect: peer %v does not exist (%v peers)", d.Peer, len(ids))
+		}
+		disconnect := &Disconnect{Peer: ids[d.Peer]}
+		if len(d.Error) > 0 {
+			disconnect.Error = errors.New(d.Error)
+		}
+		disconnects = append(disconnects, disconnect)
+	}
+	return exchanges, disconnects, nil
+}
+
+// TestScenario runs the exchanges of the scenario and checks the disconnects
+// the test fails on the first error (see RunScenario)
+func (self *ExchangeTestSession) TestScenario(types MsgTypes, sc *Scenario) {
+	if _, err := self.RunScenario(types, sc); err != nil {
+		self.t.Fatalf("scenario %v: %v", sc.Name, err)
+	}
+}
+
+// RunScenario runs the exchanges of the scenario and checks the disconnects
+// it returns the exchange results and the first error
+func (self *ExchangeTestSession) RunScenario(types MsgTypes, sc *Scenario) ([]*ExchangeResult, error) {
+	exchanges, disconnects, err := sc.Build(types, self.Ids)
+	if err != nil {
+		return nil, err
+	}
+	results, err := self.RunExchanges(exchanges...)
+	if err != nil {
+		return results, err
+	}
+	return results, self.CheckDisconnected(disconnects...)
+}
+
+// ScenarioRunner runs a directory of scenarios against a protocol
+// Protocol is called for each scenario so that scenarios do not share state
+// the sessions of the scenarios use the given timeouts
+type ScenarioRunner struct {
+	Types    MsgTypes
+	Protocol func() func(adapters.NodeAdapter) adapters.ProtoCall
+	Timeouts Timeouts
+}
+
+// ScenarioResult is the outcome of a scenario
+type ScenarioResult struct {
+	Name     string
+	Passed   bool
+	Duration time.Duration
+}
+
+// ScenarioReport lists the outcome of the scenarios run
+type ScenarioReport struct {
+	Results []*ScenarioResult
+	Passed  int
+	Failed  int
+}
+
+func (self *ScenarioReport) String() string {
+	lines := []string{fmt.Sprintf("%v scenarios: %v passed, %v failed", len(self.Results), self.Passed, self.Failed)}
+	for _, r := range self.Results {
+		result := "FAIL"
+		if r.Passed {
+			result = "PASS"
+		}
+		lines = append(lines, fmt.Sprintf("%v %v (%v)", result, r.Name, r.Duration))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Run runs each scenario found in dir as a subtest on a new protocol tester
+// session and returns the report, which is also logged
+func (self *ScenarioRunner) Run(t *testing.T, dir string) *ScenarioReport {
+	scenarios, err := LoadScenarios(dir)
+	if err != nil {
+		t.Fatalf("cannot load scenarios: %v", err)
+	}
+	report := &ScenarioReport{}
+	for _, sc := range scenarios {
+		sc := sc
+		start := time.Now()
+		passed := t.Run(sc.Name, func(t *testing.T) {
+			s := NewProtocolTester(t, RandomNodeId(), sc.peerCount(), self.Protocol())
+			s.Timeouts = self.Timeouts
+			s.TestScenario(self.Types, sc)
+		})
+		glog.V(6).Infof("scenario %v passed: %v", sc.Name, passed)
+		report.Results = append(report.Results, &ScenarioResult{Name: sc.Name, Passed: passed, Duration: time.Since(start)})
+		if passed {
+			report.Passed++
+		} else {
+			report.Failed++
+		}
+	}
+	t.Log(report)
+	return report
+}
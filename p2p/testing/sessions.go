@@ -61,11 +61,25 @@ func NewProtocolTester(t *testing.T, id *adapters.NodeId, n int, run func(id ada
 	return self
 }
 
+// Flush fails the test unless the peers connect and flush (see CheckFlush),
+// the session needs a t
 func (self *ExchangeTestSession) Flush(code int, ids ...*adapters.NodeId) {
-	self.TestConnected(false, ids...)
+	if err := self.CheckFlush(code, ids...); err != nil {
+		self.t.Fatalf("%v", err)
+	}
+}
+
+// CheckFlush waits for the peers to connect, sends them the flush message
+// with the given code and waits for them to flush, it returns the first error
+func (self *ExchangeTestSession) CheckFlush(code int, ids ...*adapters.NodeId) error {
+	if err := self.CheckConnected(false, ids...); err != nil {
+		return err
+	}
 	glog.V(6).Infof("flushing peers %v (code %v)", ids, code)
-	self.TestExchanges(flushExchange(code, ids...))
-	self.TestConnected(true, ids...)
+	if _, err := self.RunExchanges(flushExchange(code, ids...)); err != nil {
+		return err
+	}
+	return self.CheckConnected(true, ids...)
 }
 
 func (self *ExchangeSession) Start(id *adapters.NodeId) error {